@@ -0,0 +1,427 @@
+package codelab
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/googlecodelabs/tools/claat/types"
+	"time"
+)
+
+// node type names, persisted instead of the claat iota values so reordering them upstream cannot corrupt stored codelabs
+var nodeTypeNames = map[types.NodeType]string{
+	types.NodeList:        "list",
+	types.NodeGrid:        "grid",
+	types.NodeText:        "text",
+	types.NodeCode:        "code",
+	types.NodeInfobox:     "infobox",
+	types.NodeSurvey:      "survey",
+	types.NodeURL:         "url",
+	types.NodeImage:       "image",
+	types.NodeButton:      "button",
+	types.NodeItemsList:   "itemsList",
+	types.NodeItemsCheck:  "itemsCheck",
+	types.NodeItemsFAQ:    "itemsFaq",
+	types.NodeHeader:      "header",
+	types.NodeHeaderCheck: "headerCheck",
+	types.NodeHeaderFAQ:   "headerFaq",
+	types.NodeYouTube:     "youtube",
+	types.NodeIframe:      "iframe",
+	types.NodeScript:      "script",
+	types.NodeImport:      "import",
+}
+
+var nodeTypes = func() map[string]types.NodeType {
+	m := make(map[string]types.NodeType, len(nodeTypeNames))
+	for t, name := range nodeTypeNames {
+		m[name] = t
+	}
+	return m
+}()
+
+type jsonCodelab struct {
+	Meta  types.Meta  `json:"meta"`
+	Steps []*jsonStep `json:"steps"`
+}
+
+type jsonStep struct {
+	Title    string        `json:"title"`
+	Tags     []string      `json:"tags,omitempty"`
+	Duration time.Duration `json:"duration"`
+	Content  *Node         `json:"content"`
+}
+
+type Cell struct {
+	Colspan int   `json:"colspan,omitempty"`
+	Rowspan int   `json:"rowspan,omitempty"`
+	Content *Node `json:"content"`
+}
+
+// Node is the serialized form of a claat node, the union of the fields of every node type.
+type Node struct {
+	Type     string               `json:"type"`
+	Block    bool                 `json:"block,omitempty"`
+	Env      []string             `json:"env,omitempty"`
+	Nodes    []*Node              `json:"nodes,omitempty"`
+	Content  *Node                `json:"content,omitempty"`
+	Items    []*Node              `json:"items,omitempty"`
+	Rows     [][]*Cell            `json:"rows,omitempty"`
+	Value    string               `json:"value,omitempty"`
+	Bold     bool                 `json:"bold,omitempty"`
+	Italic   bool                 `json:"italic,omitempty"`
+	Code     bool                 `json:"code,omitempty"`
+	Term     bool                 `json:"term,omitempty"`
+	Lang     string               `json:"lang,omitempty"`
+	Level    int                  `json:"level,omitempty"`
+	URL      string               `json:"url,omitempty"`
+	Name     string               `json:"name,omitempty"`
+	Target   string               `json:"target,omitempty"`
+	Src      string               `json:"src,omitempty"`
+	Width    float32              `json:"width,omitempty"`
+	Alt      string               `json:"alt,omitempty"`
+	Title    string               `json:"title,omitempty"`
+	Raised   bool                 `json:"raised,omitempty"`
+	Colored  bool                 `json:"colored,omitempty"`
+	Download bool                 `json:"download,omitempty"`
+	ID       string               `json:"id,omitempty"`
+	Groups   []*types.SurveyGroup `json:"groups,omitempty"`
+	Kind     types.InfoboxKind    `json:"kind,omitempty"`
+	VideoID  string               `json:"videoId,omitempty"`
+	ListType string               `json:"listType,omitempty"`
+	Start    int                  `json:"start,omitempty"`
+}
+
+// Marshal serializes a parsed codelab, including its step node trees.
+func Marshal(c *types.Codelab) ([]byte, error) {
+	jc := &jsonCodelab{Meta: c.Meta, Steps: make([]*jsonStep, 0, len(c.Steps))}
+
+	for _, s := range c.Steps {
+		content, err := encodeNode(s.Content)
+		if err != nil {
+			return nil, err
+		}
+
+		jc.Steps = append(jc.Steps, &jsonStep{
+			Title:    s.Title,
+			Tags:     s.Tags,
+			Duration: s.Duration,
+			Content:  content,
+		})
+	}
+
+	return json.MarshalIndent(jc, "", "  ")
+}
+
+// Unmarshal restores a codelab serialized by Marshal.
+func Unmarshal(data []byte) (*types.Codelab, error) {
+	jc := &jsonCodelab{}
+	if err := json.Unmarshal(data, jc); err != nil {
+		return nil, err
+	}
+
+	c := types.NewCodelab()
+	c.Meta = jc.Meta
+	if c.Extra == nil {
+		c.Extra = map[string]string{}
+	}
+
+	for i, js := range jc.Steps {
+		if js == nil {
+			return nil, fmt.Errorf("null step %d", i)
+		}
+
+		content, err := decodeList(js.Content)
+		if err != nil {
+			return nil, err
+		}
+
+		s := c.NewStep(js.Title)
+		s.Tags = js.Tags
+		s.Duration = js.Duration
+		s.Content = content
+	}
+
+	return c, nil
+}
+
+// EncodeContent returns the serialized node tree of a step content.
+func EncodeContent(l *types.ListNode) (*Node, error) {
+	return encodeList(l)
+}
+
+func encodeNode(n types.Node) (*Node, error) {
+	if n == nil {
+		return nil, nil
+	}
+
+	name, ok := nodeTypeNames[n.Type()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported node type %d", n.Type())
+	}
+
+	jn := &Node{
+		Type:  name,
+		Block: n.Block() == true,
+		Env:   n.Env(),
+	}
+
+	var err error
+	switch n := n.(type) {
+	case *types.ListNode:
+		jn.Nodes, err = encodeNodes(n.Nodes)
+	case *types.ImportNode:
+		jn.URL = n.URL
+		jn.Content, err = encodeList(n.Content)
+	case *types.GridNode:
+		jn.Rows = make([][]*Cell, 0, len(n.Rows))
+		for _, row := range n.Rows {
+			cells := make([]*Cell, 0, len(row))
+			for _, cell := range row {
+				content, e := encodeList(cell.Content)
+				if e != nil {
+					return nil, e
+				}
+				cells = append(cells, &Cell{Colspan: cell.Colspan, Rowspan: cell.Rowspan, Content: content})
+			}
+			jn.Rows = append(jn.Rows, cells)
+		}
+	case *types.ItemsListNode:
+		jn.ListType = n.ListType
+		jn.Start = n.Start
+		jn.Items = make([]*Node, 0, len(n.Items))
+		for _, item := range n.Items {
+			ji, e := encodeList(item)
+			if e != nil {
+				return nil, e
+			}
+			jn.Items = append(jn.Items, ji)
+		}
+	case *types.TextNode:
+		jn.Bold = n.Bold
+		jn.Italic = n.Italic
+		jn.Code = n.Code
+		jn.Value = n.Value
+	case *types.CodeNode:
+		jn.Term = n.Term
+		jn.Lang = n.Lang
+		jn.Value = n.Value
+	case *types.HeaderNode:
+		jn.Level = n.Level
+		jn.Content, err = encodeList(n.Content)
+	case *types.URLNode:
+		jn.URL = n.URL
+		jn.Name = n.Name
+		jn.Target = n.Target
+		jn.Content, err = encodeList(n.Content)
+	case *types.ImageNode:
+		jn.Src = n.Src
+		jn.Width = n.Width
+		jn.Alt = n.Alt
+		jn.Title = n.Title
+	case *types.ButtonNode:
+		jn.Raised = n.Raised
+		jn.Colored = n.Colored
+		jn.Download = n.Download
+		jn.Content, err = encodeList(n.Content)
+	case *types.SurveyNode:
+		jn.ID = n.ID
+		jn.Groups = n.Groups
+	case *types.InfoboxNode:
+		jn.Kind = n.Kind
+		jn.Content, err = encodeList(n.Content)
+	case *types.YouTubeNode:
+		jn.VideoID = n.VideoID
+	case *types.IframeNode:
+		jn.URL = n.URL
+	case *types.ScriptNode:
+		jn.URL = n.URL
+	default:
+		return nil, fmt.Errorf("unsupported node %T", n)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return jn, nil
+}
+
+func encodeNodes(nodes []types.Node) ([]*Node, error) {
+	jns := make([]*Node, 0, len(nodes))
+	for _, n := range nodes {
+		jn, err := encodeNode(n)
+		if err != nil {
+			return nil, err
+		}
+		jns = append(jns, jn)
+	}
+
+	return jns, nil
+}
+
+func encodeList(l *types.ListNode) (*Node, error) {
+	if l == nil {
+		return nil, nil
+	}
+
+	return encodeNode(l)
+}
+
+func decodeNode(jn *Node) (types.Node, error) {
+	if jn == nil {
+		return nil, errors.New("null node")
+	}
+
+	typ, ok := nodeTypes[jn.Type]
+	if !ok {
+		return nil, fmt.Errorf("unsupported node type %q", jn.Type)
+	}
+
+	var n types.Node
+	switch typ {
+	case types.NodeList:
+		nodes, err := decodeNodes(jn.Nodes)
+		if err != nil {
+			return nil, err
+		}
+		n = types.NewListNode(nodes...)
+	case types.NodeImport:
+		content, err := decodeList(jn.Content)
+		if err != nil {
+			return nil, err
+		}
+		in := types.NewImportNode(jn.URL)
+		in.Content = content
+		n = in
+	case types.NodeGrid:
+		rows := make([][]*types.GridCell, 0, len(jn.Rows))
+		for _, row := range jn.Rows {
+			cells := make([]*types.GridCell, 0, len(row))
+			for _, cell := range row {
+				if cell == nil {
+					return nil, errors.New("null grid cell")
+				}
+				content, err := decodeList(cell.Content)
+				if err != nil {
+					return nil, err
+				}
+				cells = append(cells, &types.GridCell{Colspan: cell.Colspan, Rowspan: cell.Rowspan, Content: content})
+			}
+			rows = append(rows, cells)
+		}
+		n = types.NewGridNode(rows...)
+	case types.NodeItemsList, types.NodeItemsCheck, types.NodeItemsFAQ:
+		il := types.NewItemsListNode(jn.ListType, jn.Start)
+		for _, item := range jn.Items {
+			content, err := decodeList(item)
+			if err != nil {
+				return nil, err
+			}
+			il.Items = append(il.Items, content)
+		}
+		n = il
+	case types.NodeText:
+		tn := types.NewTextNode(jn.Value)
+		tn.Bold = jn.Bold
+		tn.Italic = jn.Italic
+		tn.Code = jn.Code
+		n = tn
+	case types.NodeCode:
+		n = types.NewCodeNode(jn.Value, jn.Term, jn.Lang)
+	case types.NodeHeader, types.NodeHeaderCheck, types.NodeHeaderFAQ:
+		content, err := decodeList(jn.Content)
+		if err != nil {
+			return nil, err
+		}
+		hn := types.NewHeaderNode(jn.Level)
+		hn.Content = content
+		n = hn
+	case types.NodeURL:
+		content, err := decodeList(jn.Content)
+		if err != nil {
+			return nil, err
+		}
+		un := types.NewURLNode(jn.URL)
+		un.Name = jn.Name
+		un.Target = jn.Target
+		un.Content = content
+		n = un
+	case types.NodeImage:
+		in := types.NewImageNode(jn.Src)
+		in.Width = jn.Width
+		in.Alt = jn.Alt
+		in.Title = jn.Title
+		n = in
+	case types.NodeButton:
+		content, err := decodeList(jn.Content)
+		if err != nil {
+			return nil, err
+		}
+		bn := types.NewButtonNode(jn.Raised, jn.Colored, jn.Download)
+		bn.Content = content
+		n = bn
+	case types.NodeSurvey:
+		n = types.NewSurveyNode(jn.ID, jn.Groups...)
+	case types.NodeInfobox:
+		content, err := decodeList(jn.Content)
+		if err != nil {
+			return nil, err
+		}
+		ib := types.NewInfoboxNode(jn.Kind)
+		ib.Content = content
+		n = ib
+	case types.NodeYouTube:
+		n = types.NewYouTubeNode(jn.VideoID)
+	case types.NodeIframe:
+		n = types.NewIframeNode(jn.URL)
+	case types.NodeScript:
+		n = types.NewScriptNode(jn.URL)
+	}
+
+	// constructors pick the default kind of items lists and headers
+	n.MutateType(typ)
+
+	if jn.Block {
+		n.MutateBlock(true)
+	} else if n.Block() == true {
+		n.MutateBlock(nil)
+	}
+
+	if len(jn.Env) > 0 {
+		n.MutateEnv(jn.Env)
+	}
+
+	return n, nil
+}
+
+func decodeNodes(jns []*Node) ([]types.Node, error) {
+	nodes := make([]types.Node, 0, len(jns))
+	for _, jn := range jns {
+		n, err := decodeNode(jn)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+
+	return nodes, nil
+}
+
+func decodeList(jn *Node) (*types.ListNode, error) {
+	// absent content decodes to an empty list, the renderer expects every list to exist
+	if jn == nil {
+		return types.NewListNode(), nil
+	}
+
+	n, err := decodeNode(jn)
+	if err != nil {
+		return nil, err
+	}
+
+	l, ok := n.(*types.ListNode)
+	if !ok {
+		return nil, fmt.Errorf("expected list node, got %q", jn.Type)
+	}
+
+	return l, nil
+}
@@ -0,0 +1,88 @@
+package codelab
+
+import (
+	"github.com/googlecodelabs/tools/claat/types"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestMarshalUnmarshal(t *testing.T) {
+	c := types.NewCodelab()
+	c.ID = "test-codelab"
+	c.Title = "Test Codelab"
+
+	s := c.NewStep("Overview")
+	s.Duration = time.Minute
+	s.Tags = []string{"web"}
+
+	bold := types.NewTextNode("world")
+	bold.Bold = true
+	para := types.NewListNode(types.NewTextNode("hello "), bold)
+	para.MutateBlock(true)
+
+	checklist := types.NewItemsListNode("", 0)
+	checklist.MutateType(types.NodeItemsCheck)
+	checklist.NewItem(types.NewTextNode("first"))
+
+	header := types.NewHeaderNode(2, types.NewTextNode("What you'll learn"))
+	header.MutateType(types.NodeHeaderCheck)
+
+	img := types.NewImageNode("https://example.com/image.png")
+	img.Alt = "an image"
+	img.MutateEnv([]string{"web"})
+
+	s.Content.Append(
+		para,
+		header,
+		checklist,
+		types.NewCodeNode("go run main.go", true, "bash"),
+		types.NewInfoboxNode(types.InfoboxPositive, types.NewURLNode("https://example.com", types.NewTextNode("link"))),
+		types.NewGridNode([]*types.GridCell{{Colspan: 1, Rowspan: 1, Content: types.NewListNode(img)}}),
+		types.NewButtonNode(true, false, true, types.NewTextNode("download")),
+		types.NewSurveyNode("survey", &types.SurveyGroup{Name: "q", Options: []string{"a", "b"}}),
+		types.NewYouTubeNode("video"),
+	)
+
+	data, err := Marshal(c)
+	assert.NoError(t, err)
+
+	decoded, err := Unmarshal(data)
+	assert.NoError(t, err)
+	assert.Equal(t, c, decoded)
+}
+
+func TestMarshalUnmarshalEmptyStep(t *testing.T) {
+	c := types.NewCodelab()
+	c.ID = "empty-step"
+	c.NewStep("Empty")
+
+	data, err := Marshal(c)
+	assert.NoError(t, err)
+
+	decoded, err := Unmarshal(data)
+	assert.NoError(t, err)
+	assert.Equal(t, c, decoded)
+
+	for _, data := range []string{
+		`{"steps": [{"title": "Empty", "content": null}]}`,
+		`{"steps": [{"title": "Empty"}]}`,
+	} {
+		decoded, err := Unmarshal([]byte(data))
+		assert.NoError(t, err)
+		assert.Len(t, decoded.Steps, 1)
+		assert.NotNil(t, decoded.Steps[0].Content)
+		assert.True(t, decoded.Steps[0].Content.Empty())
+	}
+}
+
+func TestUnmarshalNullNode(t *testing.T) {
+	for _, data := range []string{
+		`{"steps": [null]}`,
+		`{"steps": [{"title": "Null", "content": {"type": "list", "nodes": [null]}}]}`,
+		`{"steps": [{"title": "Null", "content": {"type": "list", "nodes": [{"type": "grid", "rows": [[null]]}]}}]}`,
+	} {
+		_, err := Unmarshal([]byte(data))
+		assert.Error(t, err, data)
+	}
+}
@@ -0,0 +1,48 @@
+package diff
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLines(t *testing.T) {
+	a := []string{"one", "two", "three", "four"}
+	b := []string{"one", "three", "3.5", "four", "five"}
+
+	lines := Lines(a, b)
+
+	assert.Equal(t, []Line{
+		{Op: OpEqual, Text: "one"},
+		{Op: OpDelete, Text: "two"},
+		{Op: OpEqual, Text: "three"},
+		{Op: OpInsert, Text: "3.5"},
+		{Op: OpEqual, Text: "four"},
+		{Op: OpInsert, Text: "five"},
+	}, lines)
+	assert.True(t, Changed(lines))
+	assert.False(t, Changed(Lines(a, a)))
+	assert.Empty(t, Lines(nil, nil))
+}
+
+func TestLinesLarge(t *testing.T) {
+	const n = 1 << 12
+	a := make([]string, 0, n+2)
+	b := make([]string, 0, n+2)
+	a = append(a, "head")
+	b = append(b, "head")
+	for i := 0; i < n; i++ {
+		a = append(a, fmt.Sprintf("a%d", i))
+		b = append(b, fmt.Sprintf("b%d", i))
+	}
+	a = append(a, "tail")
+	b = append(b, "tail")
+
+	lines := Lines(a, b)
+
+	assert.Len(t, lines, 2*n+2)
+	assert.Equal(t, Line{Op: OpEqual, Text: "head"}, lines[0])
+	assert.Equal(t, Line{Op: OpDelete, Text: "a0"}, lines[1])
+	assert.Equal(t, Line{Op: OpInsert, Text: "b0"}, lines[n+1])
+	assert.Equal(t, Line{Op: OpEqual, Text: "tail"}, lines[2*n+1])
+}
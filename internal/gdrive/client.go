@@ -93,6 +93,17 @@ func grantOwnerPermission(ctx context.Context, service *drive.Service, fileId st
 	return perm, nil
 }
 
+func revokePermission(ctx context.Context, service *drive.Service, fileId string, permissionId string) error {
+	err := service.Permissions.Delete(fileId, permissionId).Context(ctx).Do()
+
+	if err != nil {
+		log.Println("Could not unshare file: " + err.Error())
+		return err
+	}
+
+	return nil
+}
+
 func getClient() *drive.Service {
 	ctx := context.Background()
 	service, err := drive.NewService(ctx)
@@ -115,6 +126,17 @@ func getFile(ctx context.Context, service *drive.Service, fileId string) (io.Rea
 	return response.Body, nil
 }
 
+func statFile(ctx context.Context, service *drive.Service, fileId string) (*drive.File, error) {
+	file, err := service.Files.Get(fileId).Fields("id", "name", "mimeType", "owners(emailAddress,me)").Context(ctx).Do()
+
+	if err != nil {
+		log.Println("Could not stat file: " + err.Error())
+		return nil, err
+	}
+
+	return file, nil
+}
+
 func exportFile(ctx context.Context, service *drive.Service, fileId string, mimeType string) (io.ReadCloser, error) {
 	response, err := service.Files.Export(fileId, mimeType).Context(ctx).Download()
 
@@ -3,11 +3,17 @@ package gdrive
 import (
 	"golang.org/x/net/context"
 	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
 	"io"
+	"net/http"
 )
 
 type DriveFile struct {
-	Id string
+	Id       string
+	Name     string
+	MimeType string
+	// Owners are the emails of the owners of the file, the service account excluded, set by StatFile only
+	Owners []string
 }
 
 type DriveFileReader struct {
@@ -24,7 +30,9 @@ type Client interface {
 	CopyFile(ctx context.Context, sourceFileId string, destinationName string, parentId string) (*DriveFile, error)
 	GrantWritePermission(ctx context.Context, fileId string, userEmail string) (*DrivePermission, error)
 	GrantOwnerPermission(ctx context.Context, fileId string, userEmail string) (*DrivePermission, error)
+	RevokePermission(ctx context.Context, fileId string, permissionId string) error
 	GetFile(ctx context.Context, fileId string) (*DriveFileReader, error)
+	StatFile(ctx context.Context, fileId string) (*DriveFile, error)
 	ExportFile(ctx context.Context, fileId string, mimeType string) (*DriveFileReader, error)
 }
 
@@ -88,6 +96,10 @@ func (c *client) GrantOwnerPermission(ctx context.Context, fileId string, userEm
 	return &DrivePermission{Id: f.Id}, nil
 }
 
+func (c *client) RevokePermission(ctx context.Context, fileId string, permissionId string) error {
+	return revokePermission(ctx, c.service, fileId, permissionId)
+}
+
 func (c *client) GetFile(ctx context.Context, fileId string) (*DriveFileReader, error) {
 	reader, err := getFile(ctx, c.service, fileId)
 
@@ -100,6 +112,23 @@ func (c *client) GetFile(ctx context.Context, fileId string) (*DriveFileReader,
 	}, nil
 }
 
+func (c *client) StatFile(ctx context.Context, fileId string) (*DriveFile, error) {
+	f, err := statFile(ctx, c.service, fileId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	owners := make([]string, 0, len(f.Owners))
+	for _, o := range f.Owners {
+		if !o.Me && o.EmailAddress != "" {
+			owners = append(owners, o.EmailAddress)
+		}
+	}
+
+	return &DriveFile{Id: f.Id, Name: f.Name, MimeType: f.MimeType, Owners: owners}, nil
+}
+
 func (c *client) ExportFile(ctx context.Context, fileId string, mimeType string) (*DriveFileReader, error) {
 	reader, err := exportFile(ctx, c.service, fileId, mimeType)
 
@@ -111,3 +140,9 @@ func (c *client) ExportFile(ctx context.Context, fileId string, mimeType string)
 		Reader: reader,
 	}, nil
 }
+
+// IsNotFoundError reports whether err is returned for a file or a permission that does not exist.
+func IsNotFoundError(err error) bool {
+	e, ok := err.(*googleapi.Error)
+	return ok && e.Code == http.StatusNotFound
+}
@@ -4,13 +4,34 @@ import (
 	"bytes"
 	"cloud.google.com/go/storage"
 	"context"
+	"errors"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"time"
 )
 
+var ErrPreconditionFailed = errors.New("storage: precondition failed")
+
+type ObjectAttrs struct {
+	Name        string
+	Size        int64
+	ContentType string
+	Updated     time.Time
+	Generation  int64
+}
+
 type Client interface {
 	Read(ctx context.Context, object string) (*bytes.Buffer, error)
 	Write(ctx context.Context, object string, content io.Reader) (int64, error)
+	// WriteIfGenerationMatch writes the object only if its current generation equals generation,
+	// a zero generation means the object must not exist yet. ErrPreconditionFailed is returned otherwise.
+	WriteIfGenerationMatch(ctx context.Context, object string, content io.Reader, generation int64) (int64, error)
+	List(ctx context.Context, prefix string) ([]*ObjectAttrs, error)
+	Stat(ctx context.Context, object string) (*ObjectAttrs, error)
+	Delete(ctx context.Context, object string) error
 }
 
 func NewClient(bucketName string) Client {
@@ -64,6 +85,106 @@ func (c *client) Write(ctx context.Context, object string, content io.Reader) (i
 	return io.Copy(writer, content)
 }
 
+func (c *client) WriteIfGenerationMatch(ctx context.Context, object string, content io.Reader, generation int64) (int64, error) {
+	client, err := c.new(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	defer client.Close()
+
+	conditions := storage.Conditions{GenerationMatch: generation}
+	if generation == 0 {
+		conditions = storage.Conditions{DoesNotExist: true}
+	}
+
+	writer := client.Bucket(c.bucketName).Object(object).If(conditions).NewWriter(ctx)
+
+	size, err := io.Copy(writer, content)
+	if err != nil {
+		_ = writer.Close()
+		return 0, err
+	}
+
+	// preconditions are only evaluated once the upload is finalized
+	if err := writer.Close(); err != nil {
+		if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusPreconditionFailed {
+			return 0, ErrPreconditionFailed
+		}
+		return 0, err
+	}
+
+	return size, nil
+}
+
+func (c *client) List(ctx context.Context, prefix string) ([]*ObjectAttrs, error) {
+	client, err := c.new(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer client.Close()
+
+	objects := make([]*ObjectAttrs, 0)
+	it := client.Bucket(c.bucketName).Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		objects = append(objects, toObjectAttrs(attrs))
+	}
+
+	return objects, nil
+}
+
+func (c *client) Stat(ctx context.Context, object string) (*ObjectAttrs, error) {
+	client, err := c.new(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer client.Close()
+
+	attrs, err := client.Bucket(c.bucketName).Object(object).Attrs(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return toObjectAttrs(attrs), nil
+}
+
+func (c *client) Delete(ctx context.Context, object string) error {
+	client, err := c.new(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer client.Close()
+
+	return client.Bucket(c.bucketName).Object(object).Delete(ctx)
+}
+
+func toObjectAttrs(attrs *storage.ObjectAttrs) *ObjectAttrs {
+	return &ObjectAttrs{
+		Name:        attrs.Name,
+		Size:        attrs.Size,
+		ContentType: attrs.ContentType,
+		Updated:     attrs.Updated,
+		Generation:  attrs.Generation,
+	}
+}
+
 func IsNotExistError(err error) bool {
 	return err != nil && storage.ErrObjectNotExist.Error() == err.Error()
 }
+
+func IsPreconditionFailedError(err error) bool {
+	return err != nil && ErrPreconditionFailed.Error() == err.Error()
+}
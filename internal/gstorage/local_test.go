@@ -0,0 +1,88 @@
+package gstorage
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestLocalStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gstorage")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+	c := NewLocalClient(dir)
+
+	_, err = c.Read(ctx, "files-dev/x/meta.json")
+	assert.True(t, IsNotExistError(err))
+
+	size, err := c.Write(ctx, "files-dev/x/meta.json", bytes.NewBufferString(`{"a":"b"}`))
+	assert.NoError(t, err)
+	assert.EqualValues(t, 9, size)
+
+	readRes, err := c.Read(ctx, "files-dev/x/meta.json")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a":"b"}`, readRes.String())
+}
+
+func TestLocalStorageListStatDelete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gstorage")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+	c := NewLocalClient(dir)
+
+	for _, object := range []string{"files-dev/x/1/index.html", "files-dev/x/1/meta.json", "files-dev/y/1/meta.json"} {
+		_, err := c.Write(ctx, object, bytes.NewBufferString(`{}`))
+		assert.NoError(t, err)
+	}
+
+	objects, err := c.List(ctx, "files-dev/x/")
+	assert.NoError(t, err)
+	assert.Len(t, objects, 2)
+	assert.Equal(t, "files-dev/x/1/index.html", objects[0].Name)
+	assert.Equal(t, "files-dev/x/1/meta.json", objects[1].Name)
+
+	objects, err = c.List(ctx, "files-dev/z/")
+	assert.NoError(t, err)
+	assert.Len(t, objects, 0)
+
+	attrs, err := c.Stat(ctx, "files-dev/x/1/meta.json")
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, attrs.Size)
+	assert.Equal(t, "application/json", attrs.ContentType)
+
+	assert.NoError(t, c.Delete(ctx, "files-dev/x/1/meta.json"))
+	_, err = c.Stat(ctx, "files-dev/x/1/meta.json")
+	assert.True(t, IsNotExistError(err))
+	assert.True(t, IsNotExistError(c.Delete(ctx, "files-dev/x/1/meta.json")))
+}
+
+func TestLocalStorageWriteIfGenerationMatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gstorage")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+	c := NewLocalClient(dir)
+
+	_, err = c.WriteIfGenerationMatch(ctx, "files-dev/x/1/meta.json", bytes.NewBufferString(`{}`), 0)
+	assert.NoError(t, err)
+
+	_, err = c.WriteIfGenerationMatch(ctx, "files-dev/x/1/meta.json", bytes.NewBufferString(`{}`), 0)
+	assert.True(t, IsPreconditionFailedError(err))
+
+	attrs, err := c.Stat(ctx, "files-dev/x/1/meta.json")
+	assert.NoError(t, err)
+
+	_, err = c.WriteIfGenerationMatch(ctx, "files-dev/x/1/meta.json", bytes.NewBufferString(`{"a":"b"}`), attrs.Generation)
+	assert.NoError(t, err)
+
+	_, err = c.WriteIfGenerationMatch(ctx, "files-dev/x/1/meta.json", bytes.NewBufferString(`{"a":"c"}`), attrs.Generation)
+	assert.True(t, IsPreconditionFailedError(err))
+}
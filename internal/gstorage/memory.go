@@ -0,0 +1,136 @@
+package gstorage
+
+import (
+	"bytes"
+	"cloud.google.com/go/storage"
+	"context"
+	"io"
+	"io/ioutil"
+	"mime"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewMemoryClient returns a client keeping every object in memory, mostly useful for tests.
+func NewMemoryClient() Client {
+	return &memoryClient{objects: map[string]*memoryObject{}}
+}
+
+type memoryObject struct {
+	data  []byte
+	attrs ObjectAttrs
+}
+
+type memoryClient struct {
+	objects    map[string]*memoryObject
+	generation int64
+	mu         sync.Mutex
+}
+
+func (c *memoryClient) Read(ctx context.Context, object string) (*bytes.Buffer, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	o, ok := c.objects[object]
+	if !ok {
+		return nil, storage.ErrObjectNotExist
+	}
+
+	return bytes.NewBuffer(append([]byte(nil), o.data...)), nil
+}
+
+func (c *memoryClient) Write(ctx context.Context, object string, content io.Reader) (int64, error) {
+	b, err := ioutil.ReadAll(content)
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.write(object, b), nil
+}
+
+func (c *memoryClient) WriteIfGenerationMatch(ctx context.Context, object string, content io.Reader, generation int64) (int64, error) {
+	b, err := ioutil.ReadAll(content)
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	current := int64(0)
+	if o, ok := c.objects[object]; ok {
+		current = o.attrs.Generation
+	}
+
+	if current != generation {
+		return 0, ErrPreconditionFailed
+	}
+
+	return c.write(object, b), nil
+}
+
+func (c *memoryClient) write(object string, b []byte) int64 {
+	c.generation++
+	c.objects[object] = &memoryObject{
+		data: b,
+		attrs: ObjectAttrs{
+			Name:        object,
+			Size:        int64(len(b)),
+			ContentType: mime.TypeByExtension(path.Ext(object)),
+			Updated:     time.Now(),
+			Generation:  c.generation,
+		},
+	}
+
+	return int64(len(b))
+}
+
+func (c *memoryClient) List(ctx context.Context, prefix string) ([]*ObjectAttrs, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	objects := make([]*ObjectAttrs, 0)
+	for name, o := range c.objects {
+		if strings.HasPrefix(name, prefix) {
+			attrs := o.attrs
+			objects = append(objects, &attrs)
+		}
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Name < objects[j].Name
+	})
+
+	return objects, nil
+}
+
+func (c *memoryClient) Stat(ctx context.Context, object string) (*ObjectAttrs, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	o, ok := c.objects[object]
+	if !ok {
+		return nil, storage.ErrObjectNotExist
+	}
+
+	attrs := o.attrs
+	return &attrs, nil
+}
+
+func (c *memoryClient) Delete(ctx context.Context, object string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.objects[object]; !ok {
+		return storage.ErrObjectNotExist
+	}
+
+	delete(c.objects, object)
+	return nil
+}
@@ -0,0 +1,155 @@
+package linkcheck
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Checker checks that links can be followed.
+type Checker interface {
+	// Check requests every url once and returns the result of each url.
+	Check(ctx context.Context, urls []string) map[string]*Result
+}
+
+type Result struct {
+	// Status is the status code of the last response, 0 when no response was received
+	Status int
+	Err    error
+}
+
+// Broken reports whether the link could not be followed.
+func (r *Result) Broken() bool {
+	return r.Err != nil || r.Status >= http.StatusBadRequest
+}
+
+// NewChecker returns a checker requesting at most concurrency urls at once,
+// requests to the same host being at least hostInterval apart, across every check of the checker.
+func NewChecker(client *http.Client, concurrency int, hostInterval time.Duration) Checker {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	return &checker{
+		client:       client,
+		concurrency:  concurrency,
+		hostInterval: hostInterval,
+		next:         map[string]time.Time{},
+	}
+}
+
+// maxHosts is the number of hosts above which hosts free to be requested are forgotten.
+const maxHosts = 1024
+
+type checker struct {
+	client       *http.Client
+	concurrency  int
+	hostInterval time.Duration
+	mu           sync.Mutex
+	next         map[string]time.Time
+}
+
+func (c *checker) Check(ctx context.Context, urls []string) map[string]*Result {
+	results := make(map[string]*Result, len(urls))
+	seen := make(map[string]bool, len(urls))
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, c.concurrency)
+
+	for _, u := range urls {
+		if seen[u] {
+			continue
+		}
+		seen[u] = true
+
+		wg.Add(1)
+		semaphore <- struct{}{}
+
+		go func(u string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			result := c.check(ctx, u)
+
+			mu.Lock()
+			results[u] = result
+			mu.Unlock()
+		}(u)
+	}
+
+	wg.Wait()
+
+	return results
+}
+
+// check requests the head of the url, servers refusing head requests are sent a get request.
+func (c *checker) check(ctx context.Context, rawURL string) *Result {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return &Result{Err: err}
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return &Result{Err: fmt.Errorf("unsupported scheme %q", u.Scheme)}
+	}
+
+	result := c.request(ctx, http.MethodHead, u)
+	if result.Err == nil && result.Status >= http.StatusBadRequest {
+		result = c.request(ctx, http.MethodGet, u)
+	}
+
+	return result
+}
+
+func (c *checker) request(ctx context.Context, method string, u *url.URL) *Result {
+	if err := c.wait(ctx, u.Host); err != nil {
+		return &Result{Err: err}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return &Result{Err: err}
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return &Result{Err: err}
+	}
+
+	_ = res.Body.Close()
+
+	return &Result{Status: res.StatusCode}
+}
+
+// wait blocks until a request can be sent to host.
+func (c *checker) wait(ctx context.Context, host string) error {
+	c.mu.Lock()
+	now := time.Now()
+	at := c.next[host]
+	if at.Before(now) {
+		at = now
+	}
+	if len(c.next) >= maxHosts {
+		for h, next := range c.next {
+			if next.Before(now) {
+				delete(c.next, h)
+			}
+		}
+	}
+	c.next[host] = at.Add(c.hostInterval)
+	c.mu.Unlock()
+
+	timer := time.NewTimer(at.Sub(now))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,82 @@
+package linkcheck
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestChecker(t *testing.T) {
+	var mu sync.Mutex
+	requests := map[string][]string{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path] = append(requests[r.URL.Path], r.Method)
+		mu.Unlock()
+
+		switch r.URL.Path {
+		case "/ok":
+		case "/moved":
+			http.Redirect(w, r, "/ok", http.StatusMovedPermanently)
+		case "/no-head":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	checker := NewChecker(server.Client(), 2, 0)
+	results := checker.Check(context.Background(), []string{
+		server.URL + "/ok",
+		server.URL + "/moved",
+		server.URL + "/no-head",
+		server.URL + "/missing",
+		server.URL + "/ok",
+		"ftp://example.com/file",
+	})
+
+	assert.Len(t, results, 5)
+	assert.Equal(t, &Result{Status: http.StatusOK}, results[server.URL+"/ok"])
+	assert.Equal(t, &Result{Status: http.StatusOK}, results[server.URL+"/moved"])
+	assert.Equal(t, &Result{Status: http.StatusOK}, results[server.URL+"/no-head"])
+	assert.Equal(t, &Result{Status: http.StatusNotFound}, results[server.URL+"/missing"])
+	assert.True(t, results[server.URL+"/missing"].Broken())
+	assert.True(t, results["ftp://example.com/file"].Broken())
+
+	assert.Equal(t, []string{http.MethodHead, http.MethodGet}, requests["/no-head"])
+	assert.Equal(t, []string{http.MethodHead, http.MethodGet}, requests["/missing"])
+}
+
+func TestCheckerHostInterval(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	start := time.Now()
+	checker := NewChecker(server.Client(), 3, 50*time.Millisecond)
+	results := checker.Check(context.Background(), []string{server.URL + "/a", server.URL + "/b", server.URL + "/c"})
+
+	assert.Len(t, results, 3)
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+}
+
+func TestCheckerForgetsHosts(t *testing.T) {
+	c := NewChecker(http.DefaultClient, 1, 0).(*checker)
+
+	for i := 0; i < maxHosts; i++ {
+		assert.NoError(t, c.wait(context.Background(), fmt.Sprintf("host-%d", i)))
+	}
+	assert.Len(t, c.next, maxHosts)
+
+	time.Sleep(time.Millisecond)
+	assert.NoError(t, c.wait(context.Background(), "other"))
+	assert.Len(t, c.next, 1)
+}
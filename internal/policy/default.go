@@ -0,0 +1,210 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Grant assigns a role to a subject, an email or a domain.
+type Grant struct {
+	Subject string `json:"subject"`
+	Role    string `json:"role"`
+}
+
+// Store keeps the role granted to each subject, a subject has at most one role.
+type Store interface {
+	List(ctx context.Context) ([]*Grant, error)
+	// Role returns the role granted to subject, empty when none
+	Role(ctx context.Context, subject string) (string, error)
+	Grant(ctx context.Context, subject string, role string) error
+	Revoke(ctx context.Context, subject string) error
+}
+
+// NewMemoryStore returns a store keeping the grants in memory.
+func NewMemoryStore() Store {
+	return &memoryStore{grants: map[string]string{}}
+}
+
+type memoryStore struct {
+	mu     sync.Mutex
+	grants map[string]string
+}
+
+func (s *memoryStore) List(ctx context.Context) ([]*Grant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return sortedGrants(s.grants), nil
+}
+
+func (s *memoryStore) Role(ctx context.Context, subject string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.grants[normalize(subject)], nil
+}
+
+func (s *memoryStore) Grant(ctx context.Context, subject string, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.grants[normalize(subject)] = role
+	return nil
+}
+
+func (s *memoryStore) Revoke(ctx context.Context, subject string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.grants, normalize(subject))
+	return nil
+}
+
+// NewFileStore returns a store keeping the grants in a json file, read on every call so edits of the file apply
+// without a restart. A missing file has no grants.
+func NewFileStore(path string) Store {
+	return &fileStore{path: path}
+}
+
+type fileStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+type policyFile struct {
+	Grants []*Grant `json:"grants"`
+}
+
+func (s *fileStore) List(ctx context.Context) ([]*Grant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	grants, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	return sortedGrants(grants), nil
+}
+
+func (s *fileStore) Role(ctx context.Context, subject string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	grants, err := s.read()
+	if err != nil {
+		return "", err
+	}
+
+	return grants[normalize(subject)], nil
+}
+
+func (s *fileStore) Grant(ctx context.Context, subject string, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	grants, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	grants[normalize(subject)] = role
+	return s.write(grants)
+}
+
+func (s *fileStore) Revoke(ctx context.Context, subject string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	grants, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	delete(grants, normalize(subject))
+	return s.write(grants)
+}
+
+func (s *fileStore) read() (map[string]string, error) {
+	b, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]string{}, nil
+		}
+		return nil, err
+	}
+
+	return decodeGrants(b)
+}
+
+// write replaces the file through a temporary file, so readers never see a partial file.
+func (s *fileStore) write(grants map[string]string) error {
+	b, err := encodeGrants(grants)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(b); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// decodeGrants parses a policy file.
+func decodeGrants(b []byte) (map[string]string, error) {
+	file := &policyFile{}
+	if err := json.Unmarshal(b, file); err != nil {
+		return nil, err
+	}
+
+	grants := make(map[string]string, len(file.Grants))
+	for _, g := range file.Grants {
+		grants[normalize(g.Subject)] = g.Role
+	}
+
+	return grants, nil
+}
+
+func encodeGrants(grants map[string]string) ([]byte, error) {
+	return json.MarshalIndent(&policyFile{Grants: sortedGrants(grants)}, "", "  ")
+}
+
+func sortedGrants(grants map[string]string) []*Grant {
+	list := make([]*Grant, 0, len(grants))
+	for subject, role := range grants {
+		list = append(list, &Grant{Subject: subject, Role: role})
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Subject < list[j].Subject
+	})
+
+	return list
+}
+
+func normalize(subject string) string {
+	return strings.ToLower(strings.TrimSpace(subject))
+}
@@ -0,0 +1,70 @@
+package policy
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+	path := filepath.Join(dir, "policy.json")
+	s := NewFileStore(path)
+
+	grants, err := s.List(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, grants)
+
+	assert.NoError(t, s.Grant(ctx, "User@Example.com", "author"))
+	assert.NoError(t, s.Grant(ctx, "example.com", "viewer"))
+	assert.NoError(t, s.Grant(ctx, "user@example.com", "reviewer"))
+
+	role, err := s.Role(ctx, "USER@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "reviewer", role)
+
+	// grants are persisted
+	grants, err = NewFileStore(path).List(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []*Grant{
+		{Subject: "example.com", Role: "viewer"},
+		{Subject: "user@example.com", Role: "reviewer"},
+	}, grants)
+
+	assert.NoError(t, s.Revoke(ctx, "user@example.com"))
+	role, err = s.Role(ctx, "user@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "", role)
+
+	// edits of the file apply
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`{"grants":[{"subject":"other.com","role":"admin"}]}`), 0600))
+	role, err = s.Role(ctx, "other.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", role)
+
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+
+	assert.NoError(t, s.Grant(ctx, "user@example.com", "author"))
+	role, err := s.Role(ctx, "User@Example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "author", role)
+
+	assert.NoError(t, s.Revoke(ctx, "user@example.com"))
+	grants, err := s.List(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, grants)
+}
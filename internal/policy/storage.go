@@ -0,0 +1,98 @@
+package policy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/foxfoxio/codelabs-preview-go/internal/gstorage"
+)
+
+const maxUpdateAttempts = 10
+
+// ErrConflict is returned when the grants kept changing while being updated.
+var ErrConflict = errors.New("policy changed concurrently")
+
+// NewStorageStore returns a store keeping the grants in a json object of client, shared by every instance of the
+// service. A missing object has no grants.
+func NewStorageStore(client gstorage.Client, object string) Store {
+	return &storageStore{client: client, object: object}
+}
+
+type storageStore struct {
+	client gstorage.Client
+	object string
+}
+
+func (s *storageStore) List(ctx context.Context) ([]*Grant, error) {
+	grants, err := s.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return sortedGrants(grants), nil
+}
+
+func (s *storageStore) Role(ctx context.Context, subject string) (string, error) {
+	grants, err := s.read(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return grants[normalize(subject)], nil
+}
+
+func (s *storageStore) Grant(ctx context.Context, subject string, role string) error {
+	return s.update(ctx, func(grants map[string]string) {
+		grants[normalize(subject)] = role
+	})
+}
+
+func (s *storageStore) Revoke(ctx context.Context, subject string) error {
+	return s.update(ctx, func(grants map[string]string) {
+		delete(grants, normalize(subject))
+	})
+}
+
+func (s *storageStore) read(ctx context.Context) (map[string]string, error) {
+	b, err := s.client.Read(ctx, s.object)
+	if err != nil {
+		if gstorage.IsNotExistError(err) {
+			return map[string]string{}, nil
+		}
+		return nil, err
+	}
+
+	return decodeGrants(b.Bytes())
+}
+
+// update applies change to the grants, written only if no other instance wrote them in the meantime.
+func (s *storageStore) update(ctx context.Context, change func(grants map[string]string)) error {
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		generation := int64(0)
+		attrs, err := s.client.Stat(ctx, s.object)
+		if err == nil {
+			generation = attrs.Generation
+		} else if !gstorage.IsNotExistError(err) {
+			return err
+		}
+
+		grants, err := s.read(ctx)
+		if err != nil {
+			return err
+		}
+
+		change(grants)
+
+		b, err := encodeGrants(grants)
+		if err != nil {
+			return err
+		}
+
+		_, err = s.client.WriteIfGenerationMatch(ctx, s.object, bytes.NewBuffer(b), generation)
+		if !gstorage.IsPreconditionFailedError(err) {
+			return err
+		}
+	}
+
+	return ErrConflict
+}
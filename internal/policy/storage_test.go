@@ -0,0 +1,53 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"github.com/foxfoxio/codelabs-preview-go/internal/gstorage"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func TestStorageStore(t *testing.T) {
+	ctx := context.Background()
+	client := gstorage.NewMemoryClient()
+	s := NewStorageStore(client, "files/policy.json")
+
+	grants, err := s.List(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, grants)
+
+	assert.NoError(t, s.Grant(ctx, "User@Example.com", "author"))
+	assert.NoError(t, s.Grant(ctx, "example.com", "viewer"))
+
+	// grants are shared by the instances reading the same object
+	role, err := NewStorageStore(client, "files/policy.json").Role(ctx, "user@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "author", role)
+
+	assert.NoError(t, s.Revoke(ctx, "user@example.com"))
+	grants, err = s.List(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []*Grant{{Subject: "example.com", Role: "viewer"}}, grants)
+}
+
+func TestStorageStoreConcurrent(t *testing.T) {
+	ctx := context.Background()
+	client := gstorage.NewMemoryClient()
+
+	// each instance of the service has its own store
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, NewStorageStore(client, "policy.json").Grant(ctx, fmt.Sprintf("user%d@example.com", i), "author"))
+		}(i)
+	}
+	wg.Wait()
+
+	grants, err := NewStorageStore(client, "policy.json").List(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, grants, 5)
+}
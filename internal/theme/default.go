@@ -0,0 +1,86 @@
+package theme
+
+import (
+	"context"
+	"errors"
+	"github.com/foxfoxio/codelabs-preview-go/internal/gstorage"
+	"html/template"
+	"path"
+	"regexp"
+	"sync"
+)
+
+const fileExt = ".html"
+
+var ErrNotFound = errors.New("theme not found")
+
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Loader loads named html templates.
+type Loader interface {
+	Load(ctx context.Context, name string) (*template.Template, error)
+}
+
+// NewLoader returns a loader reading the theme templates from the dir of the storage client,
+// templates are parsed once and parsed again when the stored file changes.
+func NewLoader(client gstorage.Client, dir string) Loader {
+	return &loader{
+		client: client,
+		dir:    dir,
+		cache:  map[string]*cachedTemplate{},
+	}
+}
+
+type cachedTemplate struct {
+	generation int64
+	template   *template.Template
+}
+
+type loader struct {
+	client gstorage.Client
+	dir    string
+	mu     sync.Mutex
+	cache  map[string]*cachedTemplate
+}
+
+func (l *loader) Load(ctx context.Context, name string) (*template.Template, error) {
+	if !namePattern.MatchString(name) {
+		return nil, ErrNotFound
+	}
+
+	object := path.Join(l.dir, name+fileExt)
+	attrs, err := l.client.Stat(ctx, object)
+	if err != nil {
+		if gstorage.IsNotExistError(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	l.mu.Lock()
+	cached, ok := l.cache[name]
+	l.mu.Unlock()
+
+	if ok && cached.generation == attrs.Generation {
+		return cached.template, nil
+	}
+
+	b, err := l.client.Read(ctx, object)
+	if err != nil {
+		if gstorage.IsNotExistError(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	t, err := template.New(name).Parse(b.String())
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	l.cache[name] = &cachedTemplate{generation: attrs.Generation, template: t}
+	l.mu.Unlock()
+
+	return t, nil
+}
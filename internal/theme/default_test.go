@@ -0,0 +1,49 @@
+package theme
+
+import (
+	"bytes"
+	"context"
+	"github.com/foxfoxio/codelabs-preview-go/internal/gstorage"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestLoader(t *testing.T) {
+	ctx := context.Background()
+	client := gstorage.NewMemoryClient()
+	loader := NewLoader(client, "themes")
+
+	_, err := loader.Load(ctx, "brand")
+	assert.Equal(t, ErrNotFound, err)
+
+	_, err = loader.Load(ctx, "../secret")
+	assert.Equal(t, ErrNotFound, err)
+
+	_, err = client.Write(ctx, "themes/brand.html", strings.NewReader(`<h1>{{.}}</h1>`))
+	assert.NoError(t, err)
+
+	tmpl, err := loader.Load(ctx, "brand")
+	assert.NoError(t, err)
+
+	var buffer bytes.Buffer
+	assert.NoError(t, tmpl.Execute(&buffer, "<b>"))
+	assert.Equal(t, "<h1>&lt;b&gt;</h1>", buffer.String())
+
+	// changed templates are loaded again
+	_, err = client.Write(ctx, "themes/brand.html", strings.NewReader(`<h2>{{.}}</h2>`))
+	assert.NoError(t, err)
+
+	tmpl, err = loader.Load(ctx, "brand")
+	assert.NoError(t, err)
+
+	buffer.Reset()
+	assert.NoError(t, tmpl.Execute(&buffer, "title"))
+	assert.Equal(t, "<h2>title</h2>", buffer.String())
+
+	_, err = client.Write(ctx, "themes/broken.html", strings.NewReader(`{{.`))
+	assert.NoError(t, err)
+
+	_, err = loader.Load(ctx, "broken")
+	assert.Error(t, err)
+}
@@ -0,0 +1,187 @@
+package token
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// FirebaseKeysURL serves the keys firebase id tokens are signed with
+	FirebaseKeysURL = "https://www.googleapis.com/service_accounts/v1/jwk/securetoken@system.gserviceaccount.com"
+
+	defaultKeysMaxAge = time.Hour
+	// minKeysRefresh limits how often unknown key ids fetch the keys again
+	minKeysRefresh = time.Minute
+)
+
+// KeySource returns the public keys tokens are signed with.
+type KeySource interface {
+	Key(ctx context.Context, kid string) (*rsa.PublicKey, error)
+}
+
+// NewJWKSKeySource returns the keys of the JWKS served at url. Keys are cached for the max age of the response
+// and fetched again when a token is signed with an unknown key, so rotated keys are picked up.
+func NewJWKSKeySource(client *http.Client, url string) KeySource {
+	return &remoteKeySource{
+		client: client,
+		url:    url,
+		now:    time.Now,
+	}
+}
+
+// NewJWKSFileKeySource returns the keys of a local JWKS file, read once.
+func NewJWKSFileKeySource(path string) (KeySource, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := parseJWKS(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return staticKeySource(keys), nil
+}
+
+type staticKeySource map[string]*rsa.PublicKey
+
+func (s staticKeySource) Key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	if key, ok := s[kid]; ok {
+		return key, nil
+	}
+
+	return nil, ErrUnknownKey
+}
+
+type remoteKeySource struct {
+	client    *http.Client
+	url       string
+	now       func() time.Time
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+	expiresAt time.Time
+}
+
+func (s *remoteKeySource) Key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	key, ok := s.keys[kid]
+	if ok && now.Before(s.expiresAt) {
+		return key, nil
+	}
+
+	// unknown keys fetch the keys again, at most once per minKeysRefresh
+	if now.Before(s.expiresAt) && now.Sub(s.fetchedAt) < minKeysRefresh {
+		return nil, ErrUnknownKey
+	}
+
+	if err := s.fetch(ctx, now); err != nil {
+		return nil, err
+	}
+
+	if key, ok := s.keys[kid]; ok {
+		return key, nil
+	}
+
+	return nil, ErrUnknownKey
+}
+
+func (s *remoteKeySource) fetch(ctx context.Context, now time.Time) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetch keys: unexpected status %d", res.StatusCode)
+	}
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	keys, err := parseJWKS(b)
+	if err != nil {
+		return err
+	}
+
+	s.keys = keys
+	s.fetchedAt = now
+	s.expiresAt = now.Add(maxAge(res.Header.Get("Cache-Control")))
+
+	return nil
+}
+
+// maxAge returns the max-age of a Cache-Control header, defaultKeysMaxAge when absent.
+func maxAge(cacheControl string) time.Duration {
+	var seconds int
+	for _, directive := range strings.Split(cacheControl, ",") {
+		if _, err := fmt.Sscanf(strings.TrimSpace(directive), "max-age=%d", &seconds); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+
+	return defaultKeysMaxAge
+}
+
+type jwks struct {
+	Keys []*struct {
+		Kid string `json:"kid"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"keys"`
+}
+
+// parseJWKS returns the RSA keys of a JWKS document by key id.
+func parseJWKS(b []byte) (map[string]*rsa.PublicKey, error) {
+	set := &jwks{}
+	if err := json.Unmarshal(b, set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || k.Kid == "" {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %v", k.Kid, err)
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %v", k.Kid, err)
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	return keys, nil
+}
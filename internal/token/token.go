@@ -4,12 +4,19 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/gob"
-	"encoding/json"
+	"errors"
 	"golang.org/x/oauth2"
 	"strings"
 	"time"
 )
 
+const bearerScheme = "Bearer"
+
+var (
+	ErrMissingToken           = errors.New("missing token")
+	ErrMalformedAuthorization = errors.New("malformed authorization header")
+)
+
 type JwtClaims struct {
 	Iss           string `json:"iss,omitempty"`
 	Azp           string `json:"azp,omitempty"`
@@ -23,6 +30,7 @@ type JwtClaims struct {
 	Nonce         string `json:"nonce,omitempty"`
 	Iat           int    `json:"iat,omitempty"`
 	Exp           int    `json:"exp,omitempty"`
+	AuthTime      int    `json:"auth_time,omitempty"`
 	Name          string `json:"name,omitempty"`
 	Picture       string `json:"picture,omitempty"`
 	GivenName     string `json:"given_name,omitempty"`
@@ -38,22 +46,56 @@ func (j *JwtClaims) ExpiresAt() time.Time {
 	return time.Unix(int64(j.Exp), 0)
 }
 
+func (j *JwtClaims) AuthenticatedAt() time.Time {
+	return time.Unix(int64(j.AuthTime), 0)
+}
+
+// Domain returns the google workspace domain of the user, or the domain of a verified email.
+func (j *JwtClaims) Domain() string {
+	domain := j.Hd
+	if domain == "" && j.EmailVerified {
+		if i := strings.LastIndex(j.Email, "@"); i >= 0 {
+			domain = j.Email[i+1:]
+		}
+	}
+
+	return strings.ToLower(domain)
+}
+
 func (j *JwtClaims) Valid() bool {
 	return j.Email != "" && j.UserId != "" && time.Now().Before(j.ExpiresAt())
 }
 
+// ExtractJwtClaims decodes the claims of a token without verifying it.
 func ExtractJwtClaims(token string) (*JwtClaims, error) {
 	tokenStruct := &JwtClaims{}
 	jwtParts := strings.Split(token, ".")
-	out, _ := base64.RawURLEncoding.DecodeString(jwtParts[1])
-	err := json.Unmarshal(out, &tokenStruct)
-	if err != nil {
-		return nil, err
+	if len(jwtParts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	if err := decodeSegment(jwtParts[1], tokenStruct); err != nil {
+		return nil, ErrInvalidToken
 	}
 
 	return tokenStruct, nil
 }
 
+// ParseAuthorization returns the token of a bearer authorization header, tokens sent without a scheme are accepted as bearer tokens.
+func ParseAuthorization(header string) (string, error) {
+	fields := strings.Fields(header)
+	switch {
+	case len(fields) == 0:
+		return "", ErrMissingToken
+	case len(fields) == 1 && !strings.EqualFold(fields[0], bearerScheme):
+		return fields[0], nil
+	case len(fields) == 2 && strings.EqualFold(fields[0], bearerScheme):
+		return fields[1], nil
+	}
+
+	return "", ErrMalformedAuthorization
+}
+
 func EncodeBase64(token *oauth2.Token) (string, error) {
 	var buffer bytes.Buffer
 	if e := gob.NewEncoder(&buffer).Encode(token); e != nil {
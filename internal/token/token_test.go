@@ -19,6 +19,33 @@ func TestExtractJwtClaims(t *testing.T) {
 	fmt.Println(claim.ExpiresAt())
 }
 
+func TestExtractJwtClaimsMalformed(t *testing.T) {
+	for _, token := range []string{"", "abc", "a.b", "a.!!!.c", "a.e30.c.d"} {
+		_, err := ExtractJwtClaims(token)
+		assert.Equal(t, ErrInvalidToken, err, token)
+	}
+}
+
+func TestParseAuthorization(t *testing.T) {
+	for header, expected := range map[string]struct {
+		token string
+		err   error
+	}{
+		"":                   {err: ErrMissingToken},
+		"   ":                {err: ErrMissingToken},
+		"Bearer abc.def.gh":  {token: "abc.def.gh"},
+		"bearer abc.def.gh":  {token: "abc.def.gh"},
+		"abc.def.gh":         {token: "abc.def.gh"},
+		"Bearer":             {err: ErrMalformedAuthorization},
+		"Basic dXNlcjpwdw==": {err: ErrMalformedAuthorization},
+		"Bearer a b":         {err: ErrMalformedAuthorization},
+	} {
+		token, err := ParseAuthorization(header)
+		assert.Equal(t, expected.err, err, header)
+		assert.Equal(t, expected.token, token, header)
+	}
+}
+
 /*
 {
   "iss": "https://securetoken.google.com/foxfox-learn",
@@ -0,0 +1,128 @@
+package token
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	firebaseIssuerPrefix = "https://securetoken.google.com/"
+	// clockSkew is the difference tolerated between the clocks of the issuer and the service
+	clockSkew = time.Minute
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+	ErrUnknownKey   = errors.New("unknown signing key")
+)
+
+// Verifier verifies signed id tokens and returns their claims.
+type Verifier interface {
+	Verify(ctx context.Context, rawToken string) (*JwtClaims, error)
+}
+
+// NewFirebaseVerifier returns a verifier of the firebase id tokens of the project, signed with the keys of keys.
+func NewFirebaseVerifier(projectId string, keys KeySource) Verifier {
+	return &firebaseVerifier{
+		projectId: projectId,
+		keys:      keys,
+		now:       time.Now,
+	}
+}
+
+type firebaseVerifier struct {
+	projectId string
+	keys      KeySource
+	now       func() time.Time
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+func (v *firebaseVerifier) Verify(ctx context.Context, rawToken string) (*JwtClaims, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+
+	header := &jwtHeader{}
+	if err := decodeSegment(parts[0], header); err != nil {
+		return nil, fmt.Errorf("%w: malformed header", ErrInvalidToken)
+	}
+
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("%w: unexpected algorithm %q", ErrInvalidToken, header.Alg)
+	}
+
+	if header.Kid == "" {
+		return nil, fmt.Errorf("%w: missing key id", ErrInvalidToken)
+	}
+
+	key, err := v.keys.Key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature); err != nil {
+		return nil, fmt.Errorf("%w: invalid signature", ErrInvalidToken)
+	}
+
+	claims := &JwtClaims{}
+	if err := decodeSegment(parts[1], claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed claims", ErrInvalidToken)
+	}
+
+	if err := v.validate(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// validate checks the claims firebase requires of its id tokens.
+func (v *firebaseVerifier) validate(claims *JwtClaims) error {
+	now := v.now()
+
+	switch {
+	case claims.Iss != firebaseIssuerPrefix+v.projectId:
+		return fmt.Errorf("%w: unexpected issuer %q", ErrInvalidToken, claims.Iss)
+	case claims.Aud != v.projectId:
+		return fmt.Errorf("%w: unexpected audience %q", ErrInvalidToken, claims.Aud)
+	case claims.Sub == "" || len(claims.Sub) > 128:
+		return fmt.Errorf("%w: invalid subject", ErrInvalidToken)
+	case claims.Iat == 0 || claims.IssuedAt().After(now.Add(clockSkew)):
+		return fmt.Errorf("%w: issued in the future", ErrInvalidToken)
+	case claims.AuthTime == 0 || claims.AuthenticatedAt().After(now.Add(clockSkew)):
+		return fmt.Errorf("%w: authenticated in the future", ErrInvalidToken)
+	case !now.Before(claims.ExpiresAt().Add(clockSkew)):
+		return ErrTokenExpired
+	}
+
+	return nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,178 @@
+package token
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testProjectId = "test-project"
+
+func newTestKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	return key
+}
+
+func testJWKS(keys map[string]*rsa.PrivateKey) []byte {
+	set := map[string][]map[string]string{"keys": {}}
+	for kid, key := range keys {
+		set["keys"] = append(set["keys"], map[string]string{
+			"kid": kid,
+			"kty": "RSA",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+
+	b, _ := json.Marshal(set)
+	return b
+}
+
+func signTestToken(t *testing.T, key *rsa.PrivateKey, header map[string]string, claims map[string]interface{}) string {
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+
+	hash := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	assert.NoError(t, err)
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func testClaims() map[string]interface{} {
+	now := time.Now().Unix()
+	return map[string]interface{}{
+		"iss":       "https://securetoken.google.com/" + testProjectId,
+		"aud":       testProjectId,
+		"sub":       "user-1",
+		"user_id":   "user-1",
+		"email":     "user@example.com",
+		"iat":       now - 60,
+		"auth_time": now - 60,
+		"exp":       now + 3600,
+	}
+}
+
+func TestFirebaseVerifier(t *testing.T) {
+	ctx := context.Background()
+	key := newTestKey(t)
+	other := newTestKey(t)
+
+	dir, err := ioutil.TempDir("", "token")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "jwks.json")
+	assert.NoError(t, ioutil.WriteFile(path, testJWKS(map[string]*rsa.PrivateKey{"key-1": key}), 0600))
+
+	keys, err := NewJWKSFileKeySource(path)
+	assert.NoError(t, err)
+	verifier := NewFirebaseVerifier(testProjectId, keys)
+
+	header := map[string]string{"alg": "RS256", "kid": "key-1", "typ": "JWT"}
+	claims, err := verifier.Verify(ctx, signTestToken(t, key, header, testClaims()))
+	assert.NoError(t, err)
+	assert.Equal(t, "user-1", claims.UserId)
+	assert.Equal(t, "user@example.com", claims.Email)
+
+	with := func(key string, value interface{}) map[string]interface{} {
+		c := testClaims()
+		c[key] = value
+		return c
+	}
+
+	now := time.Now().Unix()
+	for name, tc := range map[string]struct {
+		token string
+		err   error
+	}{
+		"malformed":           {token: "not-a-token", err: ErrInvalidToken},
+		"other key":           {token: signTestToken(t, other, header, testClaims()), err: ErrInvalidToken},
+		"unknown key":         {token: signTestToken(t, key, map[string]string{"alg": "RS256", "kid": "key-2"}, testClaims()), err: ErrUnknownKey},
+		"unsigned":            {token: signTestToken(t, key, map[string]string{"alg": "none", "kid": "key-1"}, testClaims()), err: ErrInvalidToken},
+		"issuer":              {token: signTestToken(t, key, header, with("iss", "https://securetoken.google.com/other")), err: ErrInvalidToken},
+		"audience":            {token: signTestToken(t, key, header, with("aud", "other")), err: ErrInvalidToken},
+		"subject":             {token: signTestToken(t, key, header, with("sub", "")), err: ErrInvalidToken},
+		"issued in future":    {token: signTestToken(t, key, header, with("iat", now+3600)), err: ErrInvalidToken},
+		"authenticated later": {token: signTestToken(t, key, header, with("auth_time", now+3600)), err: ErrInvalidToken},
+		"expired":             {token: signTestToken(t, key, header, with("exp", now-3600)), err: ErrTokenExpired},
+	} {
+		_, err := verifier.Verify(ctx, tc.token)
+		assert.True(t, errors.Is(err, tc.err), "%s: %v", name, err)
+	}
+
+	// tampered claims break the signature
+	parts := strings.Split(signTestToken(t, key, header, testClaims()), ".")
+	tampered, _ := json.Marshal(with("email", "admin@example.com"))
+	parts[1] = base64.RawURLEncoding.EncodeToString(tampered)
+	_, err = verifier.Verify(ctx, strings.Join(parts, "."))
+	assert.True(t, errors.Is(err, ErrInvalidToken))
+}
+
+func TestJWKSKeySourceRotation(t *testing.T) {
+	ctx := context.Background()
+	key1, key2 := newTestKey(t), newTestKey(t)
+
+	var fetches int32
+	var rotated int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		if atomic.LoadInt32(&rotated) == 1 {
+			_, _ = w.Write(testJWKS(map[string]*rsa.PrivateKey{"key-2": key2}))
+			return
+		}
+		_, _ = w.Write(testJWKS(map[string]*rsa.PrivateKey{"key-1": key1}))
+	}))
+	defer server.Close()
+
+	source := NewJWKSKeySource(server.Client(), server.URL).(*remoteKeySource)
+	now := time.Now()
+	source.now = func() time.Time { return now }
+
+	key, err := source.Key(ctx, "key-1")
+	assert.NoError(t, err)
+	assert.Equal(t, key1.N, key.N)
+
+	// cached keys are not fetched again
+	_, err = source.Key(ctx, "key-1")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+
+	// unknown keys fetch the keys again, at most once per minute
+	atomic.StoreInt32(&rotated, 1)
+	_, err = source.Key(ctx, "key-2")
+	assert.Equal(t, ErrUnknownKey, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+
+	now = now.Add(2 * minKeysRefresh)
+	key, err = source.Key(ctx, "key-2")
+	assert.NoError(t, err)
+	assert.Equal(t, key2.N, key.N)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+
+	// expired keys are fetched again
+	now = now.Add(2 * time.Hour)
+	_, err = source.Key(ctx, "key-1")
+	assert.Equal(t, ErrUnknownKey, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&fetches))
+}
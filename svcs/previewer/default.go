@@ -1,22 +1,40 @@
 package previewer
 
 import (
+	"context"
+	cp "github.com/foxfoxio/codelabs-preview-go/internal"
 	"github.com/foxfoxio/codelabs-preview-go/internal/gdoc"
 	"github.com/foxfoxio/codelabs-preview-go/internal/gdrive"
 	"github.com/foxfoxio/codelabs-preview-go/internal/gstorage"
+	"github.com/foxfoxio/codelabs-preview-go/internal/policy"
+	"github.com/foxfoxio/codelabs-preview-go/internal/theme"
+	"github.com/foxfoxio/codelabs-preview-go/internal/token"
 	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/endpoints"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities"
 	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/transports"
 	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/usecases"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// minSessionKeyLength is the least length of the key session cookies are signed with.
+const minSessionKeyLength = 32
+
+// policyObjectName is the object of the storage path the grants are kept in, access control is enabled when it exists.
+const policyObjectName = "policy.json"
+
 func New(rootRouter *mux.Router) {
+	log := cp.Log(context.Background(), "previewer.New")
 	clientId := os.Getenv("GOOGLE_CLIENT_ID")
 	clientSecret := os.Getenv("GOOGLE_CLIENT_SECRET")
+	sessionKey := os.Getenv("CP_SESSION_KEY")
 	config := &oauth2.Config{
 		ClientID:     clientId,
 		ClientSecret: clientSecret,
@@ -36,6 +54,26 @@ func New(rootRouter *mux.Router) {
 	adminEmail := os.Getenv("CP_ADMIN_EMAIL")
 	bucketName := os.Getenv("CP_BUCKET_NAME")
 	storagePath := os.Getenv("CP_STORAGE_PATH")
+	storageBackend := os.Getenv("CP_STORAGE_BACKEND")
+	storageRootDir := os.Getenv("CP_STORAGE_ROOT_DIR")
+	firebaseProjectId := os.Getenv("CP_FIREBASE_PROJECT_ID")
+	firebaseKeysFile := os.Getenv("CP_FIREBASE_KEYS_FILE")
+	policyFile := os.Getenv("CP_POLICY_FILE")
+	defaultRole := os.Getenv("CP_DEFAULT_ROLE")
+	renderContext := &entities.RenderContext{
+		Prefix:  os.Getenv("CP_RENDER_PREFIX"),
+		Env:     os.Getenv("CP_RENDER_ENV"),
+		Extra:   parseKeyValues(os.Getenv("CP_RENDER_EXTRA")),
+		Theme:   os.Getenv("CP_RENDER_THEME"),
+		Origins: parseList(os.Getenv("CP_RENDER_ORIGINS")),
+	}
+	lintConfig := &entities.LintConfig{
+		Rules:    parseKeyValues(os.Getenv("CP_LINT_RULES")),
+		Metadata: parseList(os.Getenv("CP_LINT_METADATA")),
+	}
+	lintConfig.MinSteps, _ = strconv.Atoi(os.Getenv("CP_LINT_MIN_STEPS"))
+	themesDir := os.Getenv("CP_THEMES_DIR")
+	themesPath := os.Getenv("CP_THEMES_PATH")
 
 	if templateId == "" {
 		templateId = "1X3kriKmznxdBrJ1U4NLVtM_kLHRJBXEjn92iZI9XcW4"
@@ -45,6 +83,10 @@ func New(rootRouter *mux.Router) {
 		driveRootId = "1uH1lq__vo-PTusArFsOduKfHk6ZhW1gX"
 	}
 
+	if firebaseProjectId == "" {
+		firebaseProjectId = "foxfox-learn"
+	}
+
 	if bucketName == "" {
 		bucketName = "codelabs-preview"
 	}
@@ -53,17 +95,102 @@ func New(rootRouter *mux.Router) {
 		storagePath = "files-dev"
 	}
 
-	store := sessions.NewCookieStore([]byte("t0p-secret"))
+	if themesPath == "" {
+		themesPath = "themes"
+	}
+
+	// signed in browsers are identified by their session cookie, a guessable key would let anyone forge one
+	if len(sessionKey) < minSessionKeyLength {
+		panic("CP_SESSION_KEY must be set to a random key of at least " + strconv.Itoa(minSessionKeyLength) + " bytes")
+	}
+
+	if defaultRole != "" && !entities.ValidRole(defaultRole) {
+		log.WithField("role", defaultRole).Error("invalid CP_DEFAULT_ROLE, no default role")
+		defaultRole = ""
+	}
+
+	if storageRootDir == "" {
+		storageRootDir = "./data"
+	}
+
+	store := sessions.NewCookieStore([]byte(sessionKey))
 	driveClient := gdrive.NewClient()
 	gdocClient := gdoc.NewClient()
-	gStorageClient := gstorage.NewClient(bucketName)
+
+	var gStorageClient gstorage.Client
+	switch storageBackend {
+	case "local":
+		gStorageClient = gstorage.NewLocalClient(storageRootDir)
+	default:
+		gStorageClient = gstorage.NewClient(bucketName)
+	}
+
+	// themes are read from a local directory when given, from the bucket otherwise
+	var themes theme.Loader
+	if themesDir != "" {
+		themes = theme.NewLoader(gstorage.NewLocalClient(themesDir), "")
+	} else {
+		themes = theme.NewLoader(gStorageClient, themesPath)
+	}
+
+	// firebase keys are read from a local JWKS file when given, fetched from google otherwise
+	var firebaseKeys token.KeySource
+	if firebaseKeysFile != "" {
+		keys, err := token.NewJWKSFileKeySource(firebaseKeysFile)
+		if err != nil {
+			panic(err)
+		}
+		firebaseKeys = keys
+	} else {
+		firebaseKeys = token.NewJWKSKeySource(&http.Client{Timeout: 10 * time.Second}, token.FirebaseKeysURL)
+	}
+
+	// access control is enabled by a policy, deploys without one keep every signed in user allowed. grants are kept
+	// along the codelabs so that every instance shares them, a local file is only meant for development
+	var policies policy.Store
+	policyObject := storagePath + "/" + policyObjectName
+	if policyFile != "" {
+		policies = policy.NewFileStore(policyFile)
+	} else if _, err := gStorageClient.Stat(context.Background(), policyObject); !gstorage.IsNotExistError(err) {
+		// a policy that cannot be read yet keeps access control on rather than open every endpoint
+		if err != nil {
+			log.WithError(err).WithField("path", policyObject).Error("stat policy failed")
+		}
+		policies = policy.NewStorageStore(gStorageClient, policyObject)
+	}
 
 	sessionUsecase := usecases.NewSession(store, "__session")
-	viewerUsecase := usecases.NewViewer(driveClient, gdocClient, gStorageClient, templateId, driveRootId, adminEmail, storagePath)
-	authUsecase := usecases.NewAuth(config)
+	viewerUsecase := usecases.NewViewer(driveClient, gdocClient, gStorageClient, templateId, driveRootId, adminEmail, storagePath, renderContext, themes, lintConfig, policies, defaultRole)
+	authUsecase := usecases.NewAuth(config, token.NewFirebaseVerifier(firebaseProjectId, firebaseKeys))
 
 	authEp := endpoints.NewAuth(sessionUsecase, authUsecase)
 	viewerEp := endpoints.NewViewer(sessionUsecase, viewerUsecase, authUsecase)
 
 	transports.RegisterHttpRouter(rootRouter, authEp, viewerEp)
 }
+
+// parseKeyValues parses comma separated key=value pairs.
+func parseKeyValues(s string) map[string]string {
+	extra := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			continue
+		}
+		extra[strings.ToLower(strings.TrimSpace(kv[0]))] = strings.TrimSpace(kv[1])
+	}
+
+	return extra
+}
+
+// parseList parses a comma separated list, nil when s is empty.
+func parseList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
@@ -49,6 +49,8 @@ func (ep *authHttpEndpoint) Oauth2Callback(w http.ResponseWriter, r *http.Reques
 
 	session.UserId = authResponse.UserId
 	session.Name = authResponse.Name
+	session.Email = authResponse.Email
+	session.Domain = authResponse.Domain
 	session.Token = authResponse.Token
 	session.State = ""
 	session.RedirectUrl = ""
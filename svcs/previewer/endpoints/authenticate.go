@@ -0,0 +1,163 @@
+package endpoints
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	cp "github.com/foxfoxio/codelabs-preview-go/internal"
+	"github.com/foxfoxio/codelabs-preview-go/internal/ctx_helper"
+	"github.com/foxfoxio/codelabs-preview-go/internal/token"
+	"github.com/foxfoxio/codelabs-preview-go/internal/utils"
+	requests2 "github.com/foxfoxio/codelabs-preview-go/svcs/previewer/endpoints/requests"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities/requests"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/usecases"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const authRealm = "codelabs-preview"
+
+// RFC 6750 error codes
+const (
+	authErrorInvalidRequest    = "invalid_request"
+	authErrorInvalidToken      = "invalid_token"
+	authErrorInsufficientScope = "insufficient_scope"
+)
+
+// authError is an authentication or authorization failure, reported with a WWW-Authenticate challenge.
+type authError struct {
+	status      int
+	code        string
+	description string
+}
+
+func (e *authError) Error() string {
+	return e.description
+}
+
+// newAuthError maps the token and usecase errors to the status and RFC 6750 error code they are reported with.
+func newAuthError(err error) *authError {
+	switch {
+	case err == token.ErrMissingToken:
+		// requests without credentials are not told about errors
+		return &authError{status: http.StatusUnauthorized, description: "missing bearer token"}
+	case err == token.ErrMalformedAuthorization:
+		return &authError{status: http.StatusBadRequest, code: authErrorInvalidRequest, description: err.Error()}
+	case errors.Is(err, token.ErrTokenExpired):
+		return &authError{status: http.StatusUnauthorized, code: authErrorInvalidToken, description: "token expired"}
+	case err == usecases.ErrEmailNotVerified:
+		return &authError{status: http.StatusUnauthorized, code: authErrorInvalidToken, description: err.Error()}
+	case err == usecases.ErrUnauthorized:
+		return &authError{status: http.StatusUnauthorized, description: "authentication required"}
+	case err == usecases.ErrForbidden:
+		return &authError{status: http.StatusForbidden, code: authErrorInsufficientScope, description: "forbidden"}
+	}
+
+	return &authError{status: http.StatusUnauthorized, code: authErrorInvalidToken, description: "invalid token"}
+}
+
+// authenticate verifies the bearer token of the request and adds the user session to ctx.
+func (ep *viewerEndpoint) authenticate(ctx context.Context, r *http.Request) (context.Context, error) {
+	log := cp.Log(ctx, "ViewerEndpoint.authenticate")
+
+	authorizationToken, err := token.ParseAuthorization(r.Header.Get("authorization"))
+	if err != nil {
+		log.WithError(err).Error("parse authorization failed")
+		return ctx, newAuthError(err)
+	}
+
+	authResponse, err := ep.authUsecase.ProcessFirebaseAuthorization(ctx, &requests.AuthProcessFirebaseAuthorizationRequest{AuthorizationToken: authorizationToken})
+
+	if err != nil {
+		log.WithError(err).Error("firebase authorization failed ")
+		return ctx, newAuthError(err)
+	}
+
+	userSession := &entities.UserSession{
+		Id:        utils.NewID(),
+		Name:      authResponse.Email,
+		UserId:    authResponse.UserId,
+		Email:     authResponse.Email,
+		Domain:    authResponse.Domain,
+		Token:     authorizationToken,
+		CreatedAt: time.Now(),
+	}
+
+	ctx = ctx_helper.AppendUserId(ctx, userSession.UserId)
+	ctx = ctx_helper.AppendSessionId(ctx, userSession.Id)
+	ctx = ctx_helper.AppendSession(ctx, userSession)
+
+	return ctx, nil
+}
+
+// viewerContext adds the user to ctx for the routes serving published codelabs, which are open to anonymous users
+// unless restricted. Bearer tokens are used when sent, browsers are identified by the session of the OAuth flow.
+func (ep *viewerEndpoint) viewerContext(ctx context.Context, r *http.Request) (context.Context, error) {
+	if r.Header.Get("authorization") != "" {
+		return ep.authenticate(ctx, r)
+	}
+
+	if session := ep.sessionUsecase.GetSession(r); session.IsValid() && session.Email != "" {
+		ctx = ctx_helper.AppendUserId(ctx, session.UserId)
+		ctx = ctx_helper.AppendSessionId(ctx, session.Id)
+		ctx = ctx_helper.AppendSession(ctx, session)
+	}
+
+	return ctx, nil
+}
+
+// sendViewError answers requests for codelabs the user may not view. Browsers without a session are sent
+// through the OAuth flow and brought back to the codelab, other clients get a challenge.
+func (ep *viewerEndpoint) sendViewError(w http.ResponseWriter, r *http.Request, err error) {
+	if err != usecases.ErrUnauthorized || r.Header.Get("authorization") != "" || !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		sendAuthError(w, err)
+		return
+	}
+
+	ctx, session := ep.sessionUsecase.GetContextAndSession(r)
+	log := cp.Log(ctx, "ViewerEndpoint.sendViewError")
+
+	res, e := ep.authUsecase.ProcessSession(ctx, &requests.AuthProcessSessionRequest{UserSession: session})
+	if e != nil || res.RedirectUrl == "" {
+		sendAuthError(w, err)
+		return
+	}
+
+	session.State = res.State
+	session.RedirectUrl = r.URL.RequestURI()
+	if e := session.Save(r, w); e != nil {
+		log.WithError(e).Error("save session failed")
+		sendAuthError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, res.RedirectUrl, http.StatusFound)
+}
+
+// sendAuthError replies with the challenge of the error and a json body describing it.
+func sendAuthError(w http.ResponseWriter, err error) {
+	e, ok := err.(*authError)
+	if !ok {
+		e = newAuthError(err)
+	}
+
+	challenge := fmt.Sprintf("Bearer realm=%q", authRealm)
+	if e.code != "" {
+		challenge += fmt.Sprintf(", error=%q, error_description=%q", e.code, e.description)
+	}
+
+	w.Header().Set("WWW-Authenticate", challenge)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.status)
+
+	js, _ := json.Marshal(newResponse(1, e.description, &requests2.HttpAuthErrorResponse{
+		Error:            e.code,
+		ErrorDescription: e.description,
+	}))
+	_, _ = w.Write(js)
+}
@@ -0,0 +1,131 @@
+package endpoints
+
+import (
+	"context"
+	"fmt"
+	"github.com/foxfoxio/codelabs-preview-go/internal/token"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities/requests"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/usecases"
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type fakeAuthUsecase struct {
+	usecases.Auth
+}
+
+func (uc *fakeAuthUsecase) ProcessFirebaseAuthorization(ctx context.Context, request *requests.AuthProcessFirebaseAuthorizationRequest) (*requests.AuthProcessFirebaseAuthorizationResponse, error) {
+	switch request.AuthorizationToken {
+	case "valid":
+		return &requests.AuthProcessFirebaseAuthorizationResponse{UserId: "user-1", Email: "user@example.com"}, nil
+	case "expired":
+		return nil, fmt.Errorf("verify: %w", token.ErrTokenExpired)
+	case "unverified":
+		return nil, usecases.ErrEmailNotVerified
+	}
+	return nil, token.ErrInvalidToken
+}
+
+func (uc *fakeAuthUsecase) ProcessSession(ctx context.Context, request *requests.AuthProcessSessionRequest) (*requests.AuthProcessSessionResponse, error) {
+	return &requests.AuthProcessSessionResponse{State: "st1", RedirectUrl: "https://accounts.example.com/auth?state=st1"}, nil
+}
+
+func TestAuthenticate(t *testing.T) {
+	ep := &viewerEndpoint{authUsecase: &fakeAuthUsecase{}}
+
+	for _, tc := range []struct {
+		authorization string
+		status        int
+		challenge     string
+		body          string
+	}{
+		{
+			authorization: "",
+			status:        http.StatusUnauthorized,
+			challenge:     `Bearer realm="codelabs-preview"`,
+			body:          `{"code":1,"message":"missing bearer token","data":{"error_description":"missing bearer token"}}`,
+		},
+		{
+			authorization: "Basic dXNlcjpwdw==",
+			status:        http.StatusBadRequest,
+			challenge:     `Bearer realm="codelabs-preview", error="invalid_request", error_description="malformed authorization header"`,
+			body:          `{"code":1,"message":"malformed authorization header","data":{"error":"invalid_request","error_description":"malformed authorization header"}}`,
+		},
+		{
+			authorization: "Bearer expired",
+			status:        http.StatusUnauthorized,
+			challenge:     `Bearer realm="codelabs-preview", error="invalid_token", error_description="token expired"`,
+			body:          `{"code":1,"message":"token expired","data":{"error":"invalid_token","error_description":"token expired"}}`,
+		},
+		{
+			authorization: "Bearer unverified",
+			status:        http.StatusUnauthorized,
+			challenge:     `Bearer realm="codelabs-preview", error="invalid_token", error_description="email not verified"`,
+			body:          `{"code":1,"message":"email not verified","data":{"error":"invalid_token","error_description":"email not verified"}}`,
+		},
+		{
+			authorization: "Bearer forged",
+			status:        http.StatusUnauthorized,
+			challenge:     `Bearer realm="codelabs-preview", error="invalid_token", error_description="invalid token"`,
+			body:          `{"code":1,"message":"invalid token","data":{"error":"invalid_token","error_description":"invalid token"}}`,
+		},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("authorization", tc.authorization)
+
+		_, err := ep.authenticate(context.Background(), r)
+		if assert.Error(t, err, tc.authorization) {
+			w := httptest.NewRecorder()
+			sendAuthError(w, err)
+			assert.Equal(t, tc.status, w.Code, tc.authorization)
+			assert.Equal(t, tc.challenge, w.Header().Get("WWW-Authenticate"), tc.authorization)
+			assert.Equal(t, tc.body, w.Body.String(), tc.authorization)
+		}
+	}
+
+	for _, authorization := range []string{"Bearer valid", "valid"} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("authorization", authorization)
+
+		ctx, err := ep.authenticate(context.Background(), r)
+		assert.NoError(t, err)
+		assert.NotNil(t, ctx)
+	}
+
+	w := httptest.NewRecorder()
+	sendAuthError(w, usecases.ErrForbidden)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, `Bearer realm="codelabs-preview", error="insufficient_scope", error_description="forbidden"`, w.Header().Get("WWW-Authenticate"))
+}
+
+func TestSendViewError(t *testing.T) {
+	ep := &viewerEndpoint{
+		sessionUsecase: usecases.NewSession(sessions.NewCookieStore([]byte("test")), "__session"),
+		authUsecase:    &fakeAuthUsecase{},
+	}
+
+	// browsers are sent through the oauth flow
+	r := httptest.NewRequest(http.MethodGet, "/v/doc?format=html", nil)
+	r.Header.Set("Accept", "text/html,application/xhtml+xml")
+	w := httptest.NewRecorder()
+	ep.sendViewError(w, r, usecases.ErrUnauthorized)
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "https://accounts.example.com/auth?state=st1", w.Header().Get("Location"))
+	assert.NotEmpty(t, w.Header().Get("Set-Cookie"))
+
+	r = httptest.NewRequest(http.MethodGet, "/v/doc/1/steps", nil)
+	r.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	ep.sendViewError(w, r, usecases.ErrUnauthorized)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Bearer realm="codelabs-preview"`, w.Header().Get("WWW-Authenticate"))
+
+	r = httptest.NewRequest(http.MethodGet, "/v/doc", nil)
+	r.Header.Set("Accept", "text/html")
+	w = httptest.NewRecorder()
+	ep.sendViewError(w, r, usecases.ErrForbidden)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
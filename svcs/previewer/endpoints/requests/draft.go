@@ -1,5 +1,10 @@
 package requests
 
+import (
+	"github.com/foxfoxio/codelabs-preview-go/internal/policy"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities"
+)
+
 type HttpDraftRequest struct {
 	Data map[string]string `json:"data"`
 }
@@ -9,9 +14,90 @@ type HttpDraftResponse struct {
 }
 
 type HttpPublishResponse struct {
+	Revision int                   `json:"revision"`
+	Issues   []*entities.LintIssue `json:"issues,omitempty"`
+}
+
+type HttpPromoteResponse struct {
 	Revision int `json:"revision"`
 }
 
 type HttpMetaResponse struct {
 	Meta map[string]interface{} `json:"meta"`
 }
+
+type HttpRevisionsResponse struct {
+	Revisions []map[string]interface{} `json:"revisions"`
+	Total     int                      `json:"total"`
+}
+
+type HttpDiffResponse struct {
+	From  int                  `json:"from"`
+	To    int                  `json:"to"`
+	Steps []*entities.StepDiff `json:"steps"`
+}
+
+type HttpRerenderResponse struct {
+	Total    int                        `json:"total"`
+	Rendered int                        `json:"rendered"`
+	Skipped  int                        `json:"skipped"`
+	Failed   int                        `json:"failed"`
+	Results  []*entities.RerenderResult `json:"results"`
+}
+
+type HttpLintResponse struct {
+	Valid  bool                  `json:"valid"`
+	Issues []*entities.LintIssue `json:"issues"`
+}
+
+type HttpLinksResponse struct {
+	Total  int                    `json:"total"`
+	Broken int                    `json:"broken"`
+	Links  []*entities.LinkResult `json:"links"`
+}
+
+type HttpStepsResponse struct {
+	Revision int              `json:"revision"`
+	Total    int              `json:"total"`
+	Steps    []*entities.Step `json:"steps"`
+}
+
+type HttpStepResponse struct {
+	Revision int            `json:"revision"`
+	Total    int            `json:"total"`
+	Step     *entities.Step `json:"step"`
+}
+
+type HttpAuthErrorResponse struct {
+	Error            string `json:"error,omitempty"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+type HttpRolesResponse struct {
+	Grants      []*policy.Grant `json:"grants"`
+	DefaultRole string          `json:"defaultRole"`
+}
+
+type HttpGrantRoleRequest struct {
+	Subject string `json:"subject"`
+	Role    string `json:"role"`
+}
+
+type HttpCollaboratorsResponse struct {
+	Owner         string                   `json:"owner"`
+	Collaborators []*entities.Collaborator `json:"collaborators"`
+}
+
+type HttpAddCollaboratorRequest struct {
+	Email string `json:"email"`
+}
+
+type HttpVisibilityRequest struct {
+	Mode    string   `json:"mode"`
+	Domains []string `json:"domains"`
+	Emails  []string `json:"emails"`
+}
+
+type HttpVisibilityResponse struct {
+	Visibility *entities.Visibility `json:"visibility"`
+}
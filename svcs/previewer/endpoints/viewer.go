@@ -1,23 +1,27 @@
 package endpoints
 
 import (
-	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	cp "github.com/foxfoxio/codelabs-preview-go/internal"
 	"github.com/foxfoxio/codelabs-preview-go/internal/ctx_helper"
-	"github.com/foxfoxio/codelabs-preview-go/internal/utils"
 	requests2 "github.com/foxfoxio/codelabs-preview-go/svcs/previewer/endpoints/requests"
 	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities"
 	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities/requests"
 	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/usecases"
 	"github.com/gorilla/mux"
+	"io"
+	"io/ioutil"
+	"mime"
 	"net/http"
+	"path"
 	"strconv"
-	"time"
+	"strings"
 )
 
+const maxMarkdownSize = 5 << 20
+
 type Viewer interface {
 	Preview(w http.ResponseWriter, r *http.Request)
 	PreviewWithQuery(w http.ResponseWriter, r *http.Request)
@@ -25,6 +29,23 @@ type Viewer interface {
 	Publish(w http.ResponseWriter, r *http.Request)
 	View(w http.ResponseWriter, r *http.Request)
 	Meta(w http.ResponseWriter, r *http.Request)
+	Revisions(w http.ResponseWriter, r *http.Request)
+	Promote(w http.ResponseWriter, r *http.Request)
+	Delete(w http.ResponseWriter, r *http.Request)
+	Diff(w http.ResponseWriter, r *http.Request)
+	Rerender(w http.ResponseWriter, r *http.Request)
+	Image(w http.ResponseWriter, r *http.Request)
+	Bundle(w http.ResponseWriter, r *http.Request)
+	Lint(w http.ResponseWriter, r *http.Request)
+	Links(w http.ResponseWriter, r *http.Request)
+	Steps(w http.ResponseWriter, r *http.Request)
+	Roles(w http.ResponseWriter, r *http.Request)
+	GrantRole(w http.ResponseWriter, r *http.Request)
+	RevokeRole(w http.ResponseWriter, r *http.Request)
+	Collaborators(w http.ResponseWriter, r *http.Request)
+	AddCollaborator(w http.ResponseWriter, r *http.Request)
+	RemoveCollaborator(w http.ResponseWriter, r *http.Request)
+	SetVisibility(w http.ResponseWriter, r *http.Request)
 }
 
 func NewViewer(sessionUsecase usecases.Session, viewerUsecase usecases.Viewer, authUsecase usecases.Auth) Viewer {
@@ -45,6 +66,13 @@ func (ep *viewerEndpoint) PreviewWithQuery(w http.ResponseWriter, r *http.Reques
 	w.Header().Set("Cache-Control", "no-store")
 	ctx := ctx_helper.NewContextFromRequest(r)
 
+	ctx, err := ep.viewerContext(ctx, r)
+
+	if err != nil {
+		sendAuthError(w, err)
+		return
+	}
+
 	fileId := r.URL.Query().Get("file_id")
 	if fileId == "" {
 		w.Header().Set("Cache-Control", "no-store")
@@ -55,14 +83,25 @@ func (ep *viewerEndpoint) PreviewWithQuery(w http.ResponseWriter, r *http.Reques
 
 	response, err := ep.viewerUsecase.Parse(ctx, &requests.ViewerParseRequest{
 		FileId: fileId,
+		Format: r.URL.Query().Get("format"),
+		Theme:  r.URL.Query().Get("theme"),
 	})
 
 	w.Header().Set("Cache-Control", "no-store")
 	if err != nil {
 		fmt.Println(err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		if err == usecases.ErrUnauthorized || err == usecases.ErrForbidden {
+			ep.sendViewError(w, r, err)
+			return
+		}
+		if err == usecases.ErrUnsupportedFormat || err == usecases.ErrUnknownTheme {
+			w.WriteHeader(http.StatusBadRequest)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
 		_, _ = fmt.Fprint(w, err.Error())
 	} else {
+		w.Header().Set("Content-Type", response.ContentType)
 		_, _ = fmt.Fprint(w, response.Response)
 	}
 }
@@ -71,6 +110,13 @@ func (ep *viewerEndpoint) Preview(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Cache-Control", "no-store")
 	ctx := ctx_helper.NewContextFromRequest(r)
 
+	ctx, err := ep.viewerContext(ctx, r)
+
+	if err != nil {
+		sendAuthError(w, err)
+		return
+	}
+
 	params := mux.Vars(r)
 	fileId := ""
 
@@ -87,14 +133,25 @@ func (ep *viewerEndpoint) Preview(w http.ResponseWriter, r *http.Request) {
 
 	response, err := ep.viewerUsecase.Parse(ctx, &requests.ViewerParseRequest{
 		FileId: fileId,
+		Format: r.URL.Query().Get("format"),
+		Theme:  r.URL.Query().Get("theme"),
 	})
 
 	w.Header().Set("Cache-Control", "no-store")
 	if err != nil {
 		fmt.Println(err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		if err == usecases.ErrUnauthorized || err == usecases.ErrForbidden {
+			ep.sendViewError(w, r, err)
+			return
+		}
+		if err == usecases.ErrUnsupportedFormat || err == usecases.ErrUnknownTheme {
+			w.WriteHeader(http.StatusBadRequest)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
 		_, _ = fmt.Fprint(w, err.Error())
 	} else {
+		w.Header().Set("Content-Type", response.ContentType)
 		_, _ = fmt.Fprint(w, response.Response)
 	}
 }
@@ -106,8 +163,7 @@ func (ep *viewerEndpoint) Draft(w http.ResponseWriter, r *http.Request) {
 	ctx, err := ep.authenticate(ctx, r)
 
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		_, _ = fmt.Fprint(w, "unauthorized")
+		sendAuthError(w, err)
 		return
 	}
 
@@ -125,6 +181,11 @@ func (ep *viewerEndpoint) Draft(w http.ResponseWriter, r *http.Request) {
 
 	res, err := ep.viewerUsecase.Draft(ctx, &requests.ViewerDraftRequest{MetaData: httpReq.Data})
 
+	if err == usecases.ErrForbidden {
+		sendAuthError(w, err)
+		return
+	}
+
 	if err != nil {
 		log.WithError(err).Error("process draft failed")
 		response = newResponse(1, err.Error(), nil)
@@ -140,8 +201,7 @@ func (ep *viewerEndpoint) Publish(w http.ResponseWriter, r *http.Request) {
 	ctx, err := ep.authenticate(ctx, r)
 
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		_, _ = fmt.Fprint(w, "unauthorized")
+		sendAuthError(w, err)
 		return
 	}
 
@@ -164,20 +224,55 @@ func (ep *viewerEndpoint) Publish(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	res, err := ep.viewerUsecase.Publish(ctx, &requests.ViewerPublishRequest{FileId: fileId})
+	checkLinks, _ := strconv.ParseBool(r.URL.Query().Get("check_links"))
+	publishRequest := &requests.ViewerPublishRequest{FileId: fileId, CheckLinks: checkLinks}
+
+	// markdown sources can be uploaded instead of being read from drive
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "text/markdown" {
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxMarkdownSize+1))
+		if err != nil || len(body) == 0 || len(body) > maxMarkdownSize {
+			response = newResponse(1, "invalid markdown body", nil)
+			return
+		}
+		publishRequest.Markdown = body
+	}
+
+	res, err := ep.viewerUsecase.Publish(ctx, publishRequest)
+
+	if err == usecases.ErrForbidden {
+		sendAuthError(w, err)
+		return
+	}
+
+	if err == usecases.ErrLintFailed {
+		response = newResponse(1, err.Error(), &requests2.HttpLintResponse{Issues: res.Issues})
+		return
+	}
+
+	if err == usecases.ErrBrokenLinks {
+		response = newResponse(1, err.Error(), &requests2.HttpLinksResponse{Total: len(res.Links), Broken: len(res.Links), Links: res.Links})
+		return
+	}
 
 	if err != nil {
 		response = newResponse(1, err.Error(), nil)
 		return
 	}
 
-	response = successResponse(&requests2.HttpPublishResponse{Revision: res.Revision})
+	response = successResponse(&requests2.HttpPublishResponse{Revision: res.Revision, Issues: res.Issues})
 }
 
 func (ep *viewerEndpoint) View(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Cache-Control", "no-store")
 	ctx := ctx_helper.NewContextFromRequest(r)
 
+	ctx, err := ep.viewerContext(ctx, r)
+
+	if err != nil {
+		sendAuthError(w, err)
+		return
+	}
+
 	params := mux.Vars(r)
 	fileId := ""
 	revision := 0
@@ -193,6 +288,7 @@ func (ep *viewerEndpoint) View(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Cache-Control", "no-store")
 			w.WriteHeader(http.StatusBadRequest)
 			_, _ = fmt.Fprint(w, "bad request")
+			return
 		} else {
 			revision = int(r)
 		}
@@ -205,58 +301,53 @@ func (ep *viewerEndpoint) View(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	format := r.URL.Query().Get("format")
+	page, isPage := params["page"]
+
+	if isPage {
+		format = entities.FormatOffline
+	} else if format == entities.FormatOffline {
+		// offline pages link to each other, they are served next to each other.
+		// the location is relative to the last segment, the revision or latest, or the file on /{fileId}
+		location := path.Base(r.URL.Path) + "/offline/index.html"
+		if _, ok := params["revision"]; !ok && !strings.HasSuffix(r.URL.Path, "/latest") {
+			location = fileId + "/latest/offline/index.html"
+		}
+		http.Redirect(w, r, location, http.StatusFound)
+		return
+	}
+
 	response, err := ep.viewerUsecase.View(ctx, &requests.ViewerViewRequest{
 		FileId:   fileId,
 		Revision: revision,
+		Rerender: r.URL.Query().Get("rerender") == "true",
+		Format:   format,
+		Page:     page,
 	})
 
 	w.Header().Set("Cache-Control", "no-store")
 	if err != nil {
-		if err.Error() == "not found" {
+		if err == usecases.ErrNotFound {
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
+		if err == usecases.ErrUnauthorized || err == usecases.ErrForbidden {
+			ep.sendViewError(w, r, err)
+			return
+		}
+		if err == usecases.ErrUnsupportedFormat {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = fmt.Fprint(w, err.Error())
+			return
+		}
 		w.WriteHeader(http.StatusInternalServerError)
 		_, _ = fmt.Fprint(w, err.Error())
 	} else {
+		w.Header().Set("Content-Type", response.ContentType)
 		_, _ = fmt.Fprint(w, response.Response)
 	}
 }
 
-func (ep *viewerEndpoint) authenticate(ctx context.Context, r *http.Request) (context.Context, error) {
-	log := cp.Log(ctx, "ViewerEndpoint.authenticate")
-	authorizationToken := ""
-	if r := r.Header.Get("authorization"); r == "" {
-		log.Error("missing authorization")
-		return ctx, errors.New("unauthorized")
-
-	} else {
-		authorizationToken = r
-	}
-
-	authResponse, err := ep.authUsecase.ProcessFirebaseAuthorization(ctx, &requests.AuthProcessFirebaseAuthorizationRequest{AuthorizationToken: authorizationToken})
-
-	if err != nil {
-		log.WithError(err).Error("firebase authorization failed ")
-		return ctx, errors.New("unauthorized")
-	}
-
-	userSession := &entities.UserSession{
-		Id:        utils.NewID(),
-		Name:      authResponse.Email,
-		UserId:    authResponse.UserId,
-		Email:     authResponse.Email,
-		Token:     authorizationToken,
-		CreatedAt: time.Now(),
-	}
-
-	ctx = ctx_helper.AppendUserId(ctx, userSession.UserId)
-	ctx = ctx_helper.AppendSessionId(ctx, userSession.Id)
-	ctx = ctx_helper.AppendSession(ctx, userSession)
-
-	return ctx, nil
-}
-
 func (ep *viewerEndpoint) Meta(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Cache-Control", "no-store")
 	ctx := ctx_helper.NewContextFromRequest(r)
@@ -265,8 +356,7 @@ func (ep *viewerEndpoint) Meta(w http.ResponseWriter, r *http.Request) {
 	ctx, err := ep.authenticate(ctx, r)
 
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		_, _ = fmt.Fprint(w, "unauthorized")
+		sendAuthError(w, err)
 		return
 	}
 
@@ -290,6 +380,7 @@ func (ep *viewerEndpoint) Meta(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Cache-Control", "no-store")
 			w.WriteHeader(http.StatusBadRequest)
 			_, _ = fmt.Fprint(w, "bad request")
+			return
 		} else {
 			revision = int(r)
 		}
@@ -307,8 +398,13 @@ func (ep *viewerEndpoint) Meta(w http.ResponseWriter, r *http.Request) {
 		Revision: revision,
 	})
 
+	if err == usecases.ErrForbidden {
+		sendAuthError(w, err)
+		return
+	}
+
 	if err != nil {
-		if err.Error() == "not found" {
+		if err == usecases.ErrNotFound {
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
@@ -323,15 +419,920 @@ func (ep *viewerEndpoint) Meta(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func structToMap(data interface{}) (map[string]interface{}, error) {
-	dataBytes, err := json.Marshal(data)
+func (ep *viewerEndpoint) Revisions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	ctx := ctx_helper.NewContextFromRequest(r)
+	log := cp.Log(ctx, "ViewerEndpoint.Revisions")
+
+	ctx, err := ep.authenticate(ctx, r)
+
 	if err != nil {
-		return nil, err
+		sendAuthError(w, err)
+		return
 	}
-	mapData := make(map[string]interface{})
-	err = json.Unmarshal(dataBytes, &mapData)
+
+	params := mux.Vars(r)
+	fileId := ""
+
+	if id, ok := params["fileId"]; ok {
+		fileId = id
+	}
+
+	if fileId == "" {
+		log.Error("empty fileId")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = fmt.Fprint(w, "bad request")
+		return
+	}
+
+	offset, err := queryInt(r, "offset")
 	if err != nil {
-		return nil, err
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = fmt.Fprint(w, "bad request")
+		return
 	}
-	return mapData, nil
+
+	limit, err := queryInt(r, "limit")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = fmt.Fprint(w, "bad request")
+		return
+	}
+
+	var response *apiResponse
+	defer func() {
+		sendResponse(w, response)
+	}()
+
+	resp, err := ep.viewerUsecase.Revisions(ctx, &requests.ViewerRevisionsRequest{
+		FileId: fileId,
+		Offset: offset,
+		Limit:  limit,
+	})
+
+	if err == usecases.ErrForbidden {
+		sendAuthError(w, err)
+		return
+	}
+
+	if err != nil {
+		response = newResponse(1, err.Error(), nil)
+		return
+	}
+
+	revisions := make([]map[string]interface{}, 0, len(resp.Revisions))
+	for _, m := range resp.Revisions {
+		meta, _ := structToMap(m)
+		revisions = append(revisions, meta)
+	}
+
+	response = successResponse(&requests2.HttpRevisionsResponse{
+		Revisions: revisions,
+		Total:     resp.Total,
+	})
+}
+
+func (ep *viewerEndpoint) Promote(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	ctx := ctx_helper.NewContextFromRequest(r)
+	log := cp.Log(ctx, "ViewerEndpoint.Promote")
+
+	ctx, err := ep.authenticate(ctx, r)
+
+	if err != nil {
+		sendAuthError(w, err)
+		return
+	}
+
+	params := mux.Vars(r)
+	fileId := params["fileId"]
+	revision, err := strconv.ParseInt(params["revision"], 10, 32)
+
+	if fileId == "" || err != nil || revision <= 0 {
+		log.Error("invalid fileId or revision")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = fmt.Fprint(w, "bad request")
+		return
+	}
+
+	res, err := ep.viewerUsecase.Promote(ctx, &requests.ViewerPromoteRequest{
+		FileId:   fileId,
+		Revision: int(revision),
+	})
+
+	if err == usecases.ErrForbidden {
+		sendAuthError(w, err)
+		return
+	}
+
+	if err == usecases.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var response *apiResponse
+	defer func() {
+		sendResponse(w, response)
+	}()
+
+	if err != nil {
+		response = newResponse(1, err.Error(), nil)
+		return
+	}
+
+	response = successResponse(&requests2.HttpPromoteResponse{Revision: res.Revision})
+}
+
+func (ep *viewerEndpoint) Delete(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	ctx := ctx_helper.NewContextFromRequest(r)
+	log := cp.Log(ctx, "ViewerEndpoint.Delete")
+
+	ctx, err := ep.authenticate(ctx, r)
+
+	if err != nil {
+		sendAuthError(w, err)
+		return
+	}
+
+	params := mux.Vars(r)
+	fileId := params["fileId"]
+	revision := 0
+
+	if rev, ok := params["revision"]; ok {
+		if r, e := strconv.ParseInt(rev, 10, 32); e != nil || r <= 0 {
+			log.Error("invalid revision")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = fmt.Fprint(w, "bad request")
+			return
+		} else {
+			revision = int(r)
+		}
+	}
+
+	if fileId == "" {
+		log.Error("empty fileId")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = fmt.Fprint(w, "bad request")
+		return
+	}
+
+	_, err = ep.viewerUsecase.Delete(ctx, &requests.ViewerDeleteRequest{
+		FileId:      fileId,
+		Revision:    revision,
+		KeepHistory: r.URL.Query().Get("keep_history") != "false",
+	})
+
+	if err == usecases.ErrForbidden {
+		sendAuthError(w, err)
+		return
+	}
+
+	if err == usecases.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var response *apiResponse
+	defer func() {
+		sendResponse(w, response)
+	}()
+
+	if err != nil {
+		response = newResponse(1, err.Error(), nil)
+		return
+	}
+
+	response = successResponse(nil)
+}
+
+func (ep *viewerEndpoint) Diff(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	ctx := ctx_helper.NewContextFromRequest(r)
+	log := cp.Log(ctx, "ViewerEndpoint.Diff")
+
+	ctx, err := ep.viewerContext(ctx, r)
+
+	if err != nil {
+		sendAuthError(w, err)
+		return
+	}
+
+	params := mux.Vars(r)
+	fileId := params["fileId"]
+	from, fromErr := parseRevision(params["from"])
+	to, toErr := parseRevision(params["to"])
+
+	if fileId == "" || fromErr != nil || toErr != nil {
+		log.Error("invalid fileId or revisions")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = fmt.Fprint(w, "bad request")
+		return
+	}
+
+	res, err := ep.viewerUsecase.Diff(ctx, &requests.ViewerDiffRequest{
+		FileId: fileId,
+		From:   from,
+		To:     to,
+	})
+
+	if err == usecases.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if err == usecases.ErrUnauthorized || err == usecases.ErrForbidden {
+		ep.sendViewError(w, r, err)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "json" {
+		var response *apiResponse
+		defer func() {
+			sendResponse(w, response)
+		}()
+
+		if err != nil {
+			response = newResponse(1, err.Error(), nil)
+			return
+		}
+
+		response = successResponse(&requests2.HttpDiffResponse{
+			From:  res.From,
+			To:    res.To,
+			Steps: res.Steps,
+		})
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = fmt.Fprint(w, err.Error())
+		return
+	}
+
+	_, _ = fmt.Fprint(w, res.Response)
+}
+
+func (ep *viewerEndpoint) Rerender(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	ctx := ctx_helper.NewContextFromRequest(r)
+
+	ctx, err := ep.authenticate(ctx, r)
+
+	if err != nil {
+		sendAuthError(w, err)
+		return
+	}
+
+	concurrency, err := queryInt(r, "concurrency")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = fmt.Fprint(w, "bad request")
+		return
+	}
+
+	res, err := ep.viewerUsecase.Rerender(ctx, &requests.ViewerRerenderRequest{
+		FileId:      r.URL.Query().Get("file_id"),
+		DryRun:      r.URL.Query().Get("dry_run") == "true",
+		Concurrency: concurrency,
+	})
+
+	if err == usecases.ErrForbidden {
+		sendAuthError(w, err)
+		return
+	}
+
+	var response *apiResponse
+	defer func() {
+		sendResponse(w, response)
+	}()
+
+	if err != nil {
+		response = newResponse(1, err.Error(), nil)
+		return
+	}
+
+	response = successResponse(&requests2.HttpRerenderResponse{
+		Total:    res.Total,
+		Rendered: res.Rendered,
+		Skipped:  res.Skipped,
+		Failed:   res.Failed,
+		Results:  res.Results,
+	})
+}
+
+func (ep *viewerEndpoint) Image(w http.ResponseWriter, r *http.Request) {
+	ctx := ctx_helper.NewContextFromRequest(r)
+	log := cp.Log(ctx, "ViewerEndpoint.Image")
+
+	ctx, err := ep.viewerContext(ctx, r)
+
+	if err != nil {
+		sendAuthError(w, err)
+		return
+	}
+
+	params := mux.Vars(r)
+	fileId := params["fileId"]
+	name := params["name"]
+	revision, err := parseRevision(params["revision"])
+
+	if fileId == "" || name == "" || err != nil {
+		log.Error("invalid fileId, revision or name")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = fmt.Fprint(w, "bad request")
+		return
+	}
+
+	res, err := ep.viewerUsecase.Image(ctx, &requests.ViewerImageRequest{
+		FileId:   fileId,
+		Revision: revision,
+		Name:     name,
+	})
+
+	if err != nil {
+		w.Header().Set("Cache-Control", "no-store")
+		if err == usecases.ErrNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err == usecases.ErrUnauthorized || err == usecases.ErrForbidden {
+			ep.sendViewError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = fmt.Fprint(w, err.Error())
+		return
+	}
+
+	// images are stored under their content hash, so a name never changes content
+	if res.Restricted {
+		w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	}
+	w.Header().Set("Content-Type", res.ContentType)
+	_, _ = w.Write(res.Content)
+}
+
+func (ep *viewerEndpoint) Bundle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	ctx := ctx_helper.NewContextFromRequest(r)
+	log := cp.Log(ctx, "ViewerEndpoint.Bundle")
+
+	ctx, err := ep.viewerContext(ctx, r)
+
+	if err != nil {
+		sendAuthError(w, err)
+		return
+	}
+
+	params := mux.Vars(r)
+	fileId := params["fileId"]
+	revision, err := parseRevision(params["revision"])
+
+	if fileId == "" || err != nil {
+		log.Error("invalid fileId or revision")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = fmt.Fprint(w, "bad request")
+		return
+	}
+
+	res, err := ep.viewerUsecase.Bundle(ctx, &requests.ViewerBundleRequest{
+		FileId:   fileId,
+		Revision: revision,
+	})
+
+	if err != nil {
+		if err == usecases.ErrNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err == usecases.ErrUnauthorized || err == usecases.ErrForbidden {
+			ep.sendViewError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = fmt.Fprint(w, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", res.Name))
+	_, _ = w.Write(res.Content)
+}
+
+// Steps returns every step of the revision, or a single step when the step path parameter is set.
+func (ep *viewerEndpoint) Steps(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	ctx := ctx_helper.NewContextFromRequest(r)
+	log := cp.Log(ctx, "ViewerEndpoint.Steps")
+
+	ctx, err := ep.viewerContext(ctx, r)
+
+	if err != nil {
+		sendAuthError(w, err)
+		return
+	}
+
+	params := mux.Vars(r)
+	fileId := params["fileId"]
+	revision, err := parseRevision(params["revision"])
+
+	step := 0
+	validStep := true
+	if s, ok := params["step"]; ok {
+		n, e := strconv.Atoi(s)
+		step, validStep = n, e == nil && n >= 1
+	}
+
+	if fileId == "" || err != nil || !validStep {
+		log.Error("invalid fileId, revision or step")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = fmt.Fprint(w, "bad request")
+		return
+	}
+
+	res, err := ep.viewerUsecase.Steps(ctx, &requests.ViewerStepsRequest{
+		FileId:   fileId,
+		Revision: revision,
+		Step:     step,
+	})
+
+	if err == usecases.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if err == usecases.ErrUnauthorized || err == usecases.ErrForbidden {
+		ep.sendViewError(w, r, err)
+		return
+	}
+
+	var response *apiResponse
+	defer func() {
+		sendResponse(w, response)
+	}()
+
+	if err != nil {
+		response = newResponse(1, err.Error(), nil)
+		return
+	}
+
+	if step != 0 {
+		response = successResponse(&requests2.HttpStepResponse{
+			Revision: res.Revision,
+			Total:    res.Total,
+			Step:     res.Steps[0],
+		})
+		return
+	}
+
+	response = successResponse(&requests2.HttpStepsResponse{
+		Revision: res.Revision,
+		Total:    res.Total,
+		Steps:    res.Steps,
+	})
+}
+
+// parseRevision parses a revision path parameter, latest is revision 0.
+func parseRevision(value string) (int, error) {
+	if value == "latest" {
+		return 0, nil
+	}
+
+	revision, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+
+	if revision < 0 {
+		return 0, errors.New("invalid revision")
+	}
+
+	return int(revision), nil
+}
+
+func queryInt(r *http.Request, key string) (int, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return 0, nil
+	}
+
+	i, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(i), nil
+}
+
+func structToMap(data interface{}) (map[string]interface{}, error) {
+	dataBytes, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	mapData := make(map[string]interface{})
+	err = json.Unmarshal(dataBytes, &mapData)
+	if err != nil {
+		return nil, err
+	}
+	return mapData, nil
+}
+
+func (ep *viewerEndpoint) Lint(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	ctx := ctx_helper.NewContextFromRequest(r)
+
+	ctx, err := ep.authenticate(ctx, r)
+
+	if err != nil {
+		sendAuthError(w, err)
+		return
+	}
+
+	fileId := mux.Vars(r)["fileId"]
+	if fileId == "" {
+		sendResponse(w, newResponse(1, "bad request", nil))
+		return
+	}
+
+	res, err := ep.viewerUsecase.Lint(ctx, &requests.ViewerLintRequest{FileId: fileId})
+
+	if err == usecases.ErrUnauthorized || err == usecases.ErrForbidden {
+		sendAuthError(w, err)
+		return
+	}
+
+	var response *apiResponse
+	defer func() {
+		sendResponse(w, response)
+	}()
+
+	if err != nil {
+		response = newResponse(1, err.Error(), nil)
+		return
+	}
+
+	response = successResponse(&requests2.HttpLintResponse{
+		Valid:  res.Valid,
+		Issues: res.Issues,
+	})
+}
+
+func (ep *viewerEndpoint) Links(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	ctx := ctx_helper.NewContextFromRequest(r)
+
+	ctx, err := ep.authenticate(ctx, r)
+
+	if err != nil {
+		sendAuthError(w, err)
+		return
+	}
+
+	fileId := mux.Vars(r)["fileId"]
+	if fileId == "" {
+		sendResponse(w, newResponse(1, "bad request", nil))
+		return
+	}
+
+	res, err := ep.viewerUsecase.Links(ctx, &requests.ViewerLinksRequest{FileId: fileId})
+
+	if err == usecases.ErrUnauthorized || err == usecases.ErrForbidden {
+		sendAuthError(w, err)
+		return
+	}
+
+	var response *apiResponse
+	defer func() {
+		sendResponse(w, response)
+	}()
+
+	if err != nil {
+		response = newResponse(1, err.Error(), nil)
+		return
+	}
+
+	response = successResponse(&requests2.HttpLinksResponse{
+		Total:  len(res.Links),
+		Broken: res.Broken,
+		Links:  res.Links,
+	})
+}
+
+func (ep *viewerEndpoint) Roles(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	ctx := ctx_helper.NewContextFromRequest(r)
+
+	ctx, err := ep.authenticate(ctx, r)
+
+	if err != nil {
+		sendAuthError(w, err)
+		return
+	}
+
+	res, err := ep.viewerUsecase.Roles(ctx, &requests.ViewerRolesRequest{})
+
+	if err == usecases.ErrForbidden {
+		sendAuthError(w, err)
+		return
+	}
+
+	var response *apiResponse
+	defer func() {
+		sendResponse(w, response)
+	}()
+
+	if err != nil {
+		response = newResponse(1, err.Error(), nil)
+		return
+	}
+
+	response = successResponse(&requests2.HttpRolesResponse{
+		Grants:      res.Grants,
+		DefaultRole: res.DefaultRole,
+	})
+}
+
+func (ep *viewerEndpoint) GrantRole(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	ctx := ctx_helper.NewContextFromRequest(r)
+	log := cp.Log(ctx, "ViewerEndpoint.GrantRole")
+
+	ctx, err := ep.authenticate(ctx, r)
+
+	if err != nil {
+		sendAuthError(w, err)
+		return
+	}
+
+	httpReq := &requests2.HttpGrantRoleRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&httpReq); err != nil {
+		log.WithError(err).Error("invalid request")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = fmt.Fprint(w, "bad request")
+		return
+	}
+
+	_, err = ep.viewerUsecase.GrantRole(ctx, &requests.ViewerGrantRoleRequest{
+		Subject: httpReq.Subject,
+		Role:    httpReq.Role,
+	})
+
+	if err == usecases.ErrForbidden {
+		sendAuthError(w, err)
+		return
+	}
+
+	if err == usecases.ErrInvalidSubject || err == usecases.ErrInvalidRole {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = fmt.Fprint(w, err.Error())
+		return
+	}
+
+	var response *apiResponse
+	defer func() {
+		sendResponse(w, response)
+	}()
+
+	if err != nil {
+		response = newResponse(1, err.Error(), nil)
+		return
+	}
+
+	response = successResponse(nil)
+}
+
+func (ep *viewerEndpoint) RevokeRole(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	ctx := ctx_helper.NewContextFromRequest(r)
+
+	ctx, err := ep.authenticate(ctx, r)
+
+	if err != nil {
+		sendAuthError(w, err)
+		return
+	}
+
+	_, err = ep.viewerUsecase.RevokeRole(ctx, &requests.ViewerRevokeRoleRequest{Subject: mux.Vars(r)["subject"]})
+
+	if err == usecases.ErrForbidden {
+		sendAuthError(w, err)
+		return
+	}
+
+	if err == usecases.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var response *apiResponse
+	defer func() {
+		sendResponse(w, response)
+	}()
+
+	if err != nil {
+		response = newResponse(1, err.Error(), nil)
+		return
+	}
+
+	response = successResponse(nil)
+}
+
+func (ep *viewerEndpoint) Collaborators(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	ctx := ctx_helper.NewContextFromRequest(r)
+
+	ctx, err := ep.authenticate(ctx, r)
+
+	if err != nil {
+		sendAuthError(w, err)
+		return
+	}
+
+	res, err := ep.viewerUsecase.Collaborators(ctx, &requests.ViewerCollaboratorsRequest{FileId: mux.Vars(r)["fileId"]})
+
+	if err == usecases.ErrForbidden {
+		sendAuthError(w, err)
+		return
+	}
+
+	if err == usecases.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var response *apiResponse
+	defer func() {
+		sendResponse(w, response)
+	}()
+
+	if err != nil {
+		response = newResponse(1, err.Error(), nil)
+		return
+	}
+
+	response = successResponse(newCollaboratorsResponse(res.Ownership))
+}
+
+func (ep *viewerEndpoint) AddCollaborator(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	ctx := ctx_helper.NewContextFromRequest(r)
+	log := cp.Log(ctx, "ViewerEndpoint.AddCollaborator")
+
+	ctx, err := ep.authenticate(ctx, r)
+
+	if err != nil {
+		sendAuthError(w, err)
+		return
+	}
+
+	httpReq := &requests2.HttpAddCollaboratorRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&httpReq); err != nil {
+		log.WithError(err).Error("invalid request")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = fmt.Fprint(w, "bad request")
+		return
+	}
+
+	res, err := ep.viewerUsecase.AddCollaborator(ctx, &requests.ViewerAddCollaboratorRequest{
+		FileId: mux.Vars(r)["fileId"],
+		Email:  httpReq.Email,
+	})
+
+	if err == usecases.ErrForbidden {
+		sendAuthError(w, err)
+		return
+	}
+
+	if err == usecases.ErrInvalidEmail {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = fmt.Fprint(w, err.Error())
+		return
+	}
+
+	if err == usecases.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var response *apiResponse
+	defer func() {
+		sendResponse(w, response)
+	}()
+
+	if err != nil {
+		response = newResponse(1, err.Error(), nil)
+		return
+	}
+
+	response = successResponse(newCollaboratorsResponse(res.Ownership))
+}
+
+func (ep *viewerEndpoint) RemoveCollaborator(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	ctx := ctx_helper.NewContextFromRequest(r)
+
+	ctx, err := ep.authenticate(ctx, r)
+
+	if err != nil {
+		sendAuthError(w, err)
+		return
+	}
+
+	params := mux.Vars(r)
+	res, err := ep.viewerUsecase.RemoveCollaborator(ctx, &requests.ViewerRemoveCollaboratorRequest{
+		FileId: params["fileId"],
+		Email:  params["email"],
+	})
+
+	if err == usecases.ErrForbidden {
+		sendAuthError(w, err)
+		return
+	}
+
+	if err == usecases.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var response *apiResponse
+	defer func() {
+		sendResponse(w, response)
+	}()
+
+	if err != nil {
+		response = newResponse(1, err.Error(), nil)
+		return
+	}
+
+	response = successResponse(newCollaboratorsResponse(res.Ownership))
+}
+
+func newCollaboratorsResponse(ownership *entities.Ownership) *requests2.HttpCollaboratorsResponse {
+	return &requests2.HttpCollaboratorsResponse{
+		Owner:         ownership.Owner,
+		Collaborators: ownership.Collaborators,
+	}
+}
+
+func (ep *viewerEndpoint) SetVisibility(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	ctx := ctx_helper.NewContextFromRequest(r)
+	log := cp.Log(ctx, "ViewerEndpoint.SetVisibility")
+
+	ctx, err := ep.authenticate(ctx, r)
+
+	if err != nil {
+		sendAuthError(w, err)
+		return
+	}
+
+	httpReq := &requests2.HttpVisibilityRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&httpReq); err != nil {
+		log.WithError(err).Error("invalid request")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = fmt.Fprint(w, "bad request")
+		return
+	}
+
+	res, err := ep.viewerUsecase.SetVisibility(ctx, &requests.ViewerSetVisibilityRequest{
+		FileId: mux.Vars(r)["fileId"],
+		Visibility: &entities.Visibility{
+			Mode:    httpReq.Mode,
+			Domains: httpReq.Domains,
+			Emails:  httpReq.Emails,
+		},
+	})
+
+	if err == usecases.ErrForbidden {
+		sendAuthError(w, err)
+		return
+	}
+
+	if err == usecases.ErrInvalidVisibility {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = fmt.Fprint(w, err.Error())
+		return
+	}
+
+	if err == usecases.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var response *apiResponse
+	defer func() {
+		sendResponse(w, response)
+	}()
+
+	if err != nil {
+		response = newResponse(1, err.Error(), nil)
+		return
+	}
+
+	response = successResponse(&requests2.HttpVisibilityResponse{Visibility: res.Visibility})
 }
@@ -0,0 +1,33 @@
+package endpoints
+
+import (
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/usecases"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestViewOfflineRedirect(t *testing.T) {
+	ep := &viewerEndpoint{sessionUsecase: usecases.NewSession(sessions.NewCookieStore([]byte("test")), "__session")}
+
+	router := mux.NewRouter()
+	v := router.PathPrefix("/v").Subrouter()
+	v.HandleFunc("/{fileId}/latest", ep.View).Methods("GET")
+	v.HandleFunc("/{fileId}/{revision}", ep.View).Methods("GET")
+	v.HandleFunc("/{fileId}", ep.View).Methods("GET")
+
+	for target, location := range map[string]string{
+		"/v/doc?format=offline":        "/v/doc/latest/offline/index.html",
+		"/v/doc/latest?format=offline": "/v/doc/latest/offline/index.html",
+		"/v/doc/3?format=offline":      "/v/doc/3/offline/index.html",
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+
+		assert.Equal(t, http.StatusFound, w.Code, target)
+		assert.Equal(t, location, w.Header().Get("Location"), target)
+	}
+}
@@ -0,0 +1,18 @@
+package entities
+
+import "github.com/foxfoxio/codelabs-preview-go/internal/diff"
+
+const (
+	StepDiffStatusUnchanged = "unchanged"
+	StepDiffStatusChanged   = "changed"
+	StepDiffStatusAdded     = "added"
+	StepDiffStatusRemoved   = "removed"
+)
+
+type StepDiff struct {
+	Index  int         `json:"index"`
+	Status string      `json:"status"`
+	Title  []diff.Line `json:"title"`
+	Text   []diff.Line `json:"text"`
+	Code   []diff.Line `json:"code"`
+}
@@ -0,0 +1,10 @@
+package entities
+
+// Output formats a codelab can be rendered to, named after the claat export formats.
+const (
+	FormatHTML     = "html"
+	FormatMarkdown = "md"
+	FormatOffline  = "offline"
+	FormatQwiklabs = "qwiklabs"
+	FormatDevsite  = "devsite"
+)
@@ -0,0 +1,11 @@
+package entities
+
+type LinkResult struct {
+	// Step is the 1-based step the link was found in
+	Step int    `json:"step"`
+	URL  string `json:"url"`
+	// Status is the status code the link responded with, 0 when it could not be requested
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Broken bool   `json:"broken"`
+}
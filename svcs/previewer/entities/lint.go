@@ -0,0 +1,36 @@
+package entities
+
+const (
+	LintSeverityError   = "error"
+	LintSeverityWarning = "warning"
+	LintSeverityOff     = "off"
+)
+
+// Lint rules a codelab is checked against before being published.
+const (
+	LintRuleMetadata     = "metadata"
+	LintRuleFeedback     = "feedback"
+	LintRuleSteps        = "steps"
+	LintRuleEmptyStep    = "empty-step"
+	LintRuleDuration     = "duration"
+	LintRuleImageAlt     = "image-alt"
+	LintRuleHeadingLevel = "heading-level"
+)
+
+// LintConfig configures the lint rules, rules left out keep their default severity.
+type LintConfig struct {
+	// Rules maps a rule to its severity, rules are disabled with LintSeverityOff
+	Rules map[string]string `json:"rules,omitempty"`
+	// Metadata lists the required metadata, named after the draft metadata keys
+	Metadata []string `json:"metadata,omitempty"`
+	// MinSteps is the least number of steps a codelab has
+	MinSteps int `json:"minSteps,omitempty"`
+}
+
+type LintIssue struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	// Step is the 1-based step the issue was found in, 0 for the codelab itself
+	Step    int    `json:"step,omitempty"`
+	Message string `json:"message"`
+}
@@ -5,9 +5,41 @@ import (
 	"time"
 )
 
+// Sources a codelab can be published from.
+const (
+	SourceGoogleDoc = "gdoc"
+	SourceMarkdown  = "markdown"
+)
+
+// Meta describes a published revision, Toc and the content counts are computed on publish.
 type Meta struct {
-	FileId       string      `json:"fileId"`
-	Revision     int         `json:"revision"`
-	ExportedDate time.Time   `json:"exportedDate"`
-	Meta         *types.Meta `json:"meta"`
+	FileId       string         `json:"fileId"`
+	Revision     int            `json:"revision"`
+	Source       string         `json:"source,omitempty"`
+	Render       *RenderContext `json:"render,omitempty"`
+	ExportedDate time.Time      `json:"exportedDate"`
+	PublishedBy  string         `json:"publishedBy,omitempty"`
+	PromotedBy   string         `json:"promotedBy,omitempty"`
+	PromotedDate *time.Time     `json:"promotedDate,omitempty"`
+	DeletedBy    string         `json:"deletedBy,omitempty"`
+	DeletedDate  *time.Time     `json:"deletedDate,omitempty"`
+	Toc          []*TocEntry    `json:"toc,omitempty"`
+	WordCount    int            `json:"wordCount,omitempty"`
+	ReadingTime  int            `json:"readingTime,omitempty"` // in minutes
+	CodeBlocks   int            `json:"codeBlocks,omitempty"`
+	Images       int            `json:"images,omitempty"`
+	Visibility   *Visibility    `json:"visibility,omitempty"` // set on the latest meta only
+	Meta         *types.Meta    `json:"meta"`
+}
+
+// TocEntry is a step of the table of contents, durations are in minutes.
+type TocEntry struct {
+	Index    int    `json:"index"`
+	Title    string `json:"title"`
+	Duration int    `json:"duration"`
+}
+
+// Deleted reports whether the meta is a tombstone left by an unpublish or a revision delete.
+func (m *Meta) Deleted() bool {
+	return m != nil && m.DeletedDate != nil
 }
@@ -0,0 +1,37 @@
+package entities
+
+import (
+	"strings"
+	"time"
+)
+
+// Ownership records who owns a codelab and who may publish, promote and delete it along the owner.
+type Ownership struct {
+	FileId        string          `json:"fileId"`
+	Owner         string          `json:"owner"`
+	CreatedDate   time.Time       `json:"createdDate"`
+	Collaborators []*Collaborator `json:"collaborators"`
+}
+
+type Collaborator struct {
+	Email        string    `json:"email"`
+	PermissionId string    `json:"permissionId,omitempty"` // drive permission granted to the collaborator
+	AddedBy      string    `json:"addedBy"`
+	AddedDate    time.Time `json:"addedDate"`
+}
+
+// Collaborator returns the collaborator with email, nil when none.
+func (o *Ownership) Collaborator(email string) *Collaborator {
+	for _, c := range o.Collaborators {
+		if strings.EqualFold(c.Email, email) {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// Allows reports whether email is the owner or a collaborator.
+func (o *Ownership) Allows(email string) bool {
+	return strings.EqualFold(o.Owner, email) || o.Collaborator(email) != nil
+}
@@ -0,0 +1,14 @@
+package entities
+
+// RenderContext holds the claat render values a codelab is rendered with.
+type RenderContext struct {
+	// Prefix is where the codelab elements are loaded from
+	Prefix string `json:"prefix"`
+	// Env selects the environment specific content blocks
+	Env string `json:"env"`
+	// Theme is the template the codelab is laid out with, the claat html template when empty
+	Theme string            `json:"theme,omitempty"`
+	Extra map[string]string `json:"extra,omitempty"`
+	// Origins are the https origins codelabs may override Prefix with, besides the origin of Prefix
+	Origins []string `json:"-"`
+}
@@ -24,6 +24,8 @@ type AuthProcessOauth2CallbackRequest struct {
 type AuthProcessOauth2CallbackResponse struct {
 	Name   string
 	UserId string
+	Email  string
+	Domain string
 	Token  string
 }
 
@@ -32,7 +34,9 @@ type AuthProcessFirebaseAuthorizationRequest struct {
 }
 
 type AuthProcessFirebaseAuthorizationResponse struct {
-	UserId    string
-	Email     string
+	UserId string
+	Email  string
+	// Domain is the google workspace domain of the user, or the domain of a verified email
+	Domain    string
 	ExpiresAt time.Time
 }
@@ -2,23 +2,54 @@ package requests
 
 import (
 	"fmt"
+	"github.com/foxfoxio/codelabs-preview-go/internal/policy"
 	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities"
 )
 
 type ViewerParseRequest struct {
 	FileId string
+	Format string
+	// Theme previews the codelab with another theme than the one of its metadata
+	Theme string
 }
 
 type ViewerParseResponse struct {
-	Response string
+	Response    string
+	ContentType string
 }
 
 type ViewerPublishRequest struct {
 	FileId string
+	// Markdown is published instead of the drive file when given
+	Markdown []byte
+	// CheckLinks refuses to publish codelabs with broken links
+	CheckLinks bool
 }
 
 type ViewerPublishResponse struct {
 	Revision int
+	// Issues are the lint warnings of the published revision, or the errors it was refused for
+	Issues []*entities.LintIssue
+	// Links are the broken links the revision was refused for
+	Links []*entities.LinkResult
+}
+
+type ViewerPromoteRequest struct {
+	FileId   string
+	Revision int
+}
+
+type ViewerPromoteResponse struct {
+	Revision int
+}
+
+type ViewerDeleteRequest struct {
+	FileId      string
+	Revision    int
+	KeepHistory bool
+}
+
+type ViewerDeleteResponse struct {
 }
 
 type ViewerMetaRequest struct {
@@ -30,13 +61,169 @@ type ViewerMetaResponse struct {
 	Meta *entities.Meta
 }
 
+type ViewerRevisionsRequest struct {
+	FileId string
+	Offset int
+	Limit  int
+}
+
+type ViewerRevisionsResponse struct {
+	Revisions []*entities.Meta
+	Total     int
+}
+
+type ViewerDiffRequest struct {
+	FileId string
+	From   int
+	To     int
+}
+
+type ViewerDiffResponse struct {
+	From     int
+	To       int
+	Steps    []*entities.StepDiff
+	Response string
+}
+
+type ViewerRerenderRequest struct {
+	FileId      string
+	DryRun      bool
+	Concurrency int
+}
+
+type ViewerRerenderResponse struct {
+	Total    int
+	Rendered int
+	Skipped  int
+	Failed   int
+	Results  []*entities.RerenderResult
+}
+
 type ViewerViewRequest struct {
 	FileId   string
 	Revision int
+	Rerender bool
+	Format   string
+	// Page of multi page formats, the index page when empty
+	Page string
 }
 
 type ViewerViewResponse struct {
-	Response string
+	Response    string
+	ContentType string
+}
+
+type ViewerImageRequest struct {
+	FileId   string
+	Revision int
+	Name     string
+}
+
+type ViewerImageResponse struct {
+	Content     []byte
+	ContentType string
+	// Restricted is set for images of codelabs that are not public
+	Restricted bool
+}
+
+type ViewerBundleRequest struct {
+	FileId   string
+	Revision int
+}
+
+type ViewerBundleResponse struct {
+	Name    string
+	Content []byte
+}
+
+type ViewerLintRequest struct {
+	FileId string
+}
+
+type ViewerLintResponse struct {
+	Valid  bool
+	Issues []*entities.LintIssue
+}
+
+type ViewerLinksRequest struct {
+	FileId string
+}
+
+type ViewerLinksResponse struct {
+	Links  []*entities.LinkResult
+	Broken int
+}
+
+type ViewerStepsRequest struct {
+	FileId   string
+	Revision int
+	// Step selects a single 1-based step, every step when 0
+	Step int
+}
+
+type ViewerStepsResponse struct {
+	Revision int
+	Total    int
+	Steps    []*entities.Step
+}
+
+type ViewerRolesRequest struct {
+}
+
+type ViewerRolesResponse struct {
+	Grants      []*policy.Grant
+	DefaultRole string
+}
+
+type ViewerGrantRoleRequest struct {
+	// Subject is an email or a domain
+	Subject string
+	Role    string
+}
+
+type ViewerGrantRoleResponse struct {
+}
+
+type ViewerRevokeRoleRequest struct {
+	Subject string
+}
+
+type ViewerRevokeRoleResponse struct {
+}
+
+type ViewerCollaboratorsRequest struct {
+	FileId string
+}
+
+type ViewerCollaboratorsResponse struct {
+	Ownership *entities.Ownership
+}
+
+type ViewerAddCollaboratorRequest struct {
+	FileId string
+	Email  string
+}
+
+type ViewerAddCollaboratorResponse struct {
+	Ownership *entities.Ownership
+}
+
+type ViewerRemoveCollaboratorRequest struct {
+	FileId string
+	Email  string
+}
+
+type ViewerRemoveCollaboratorResponse struct {
+	Ownership *entities.Ownership
+}
+
+type ViewerSetVisibilityRequest struct {
+	FileId     string
+	Visibility *entities.Visibility
+}
+
+type ViewerSetVisibilityResponse struct {
+	Visibility *entities.Visibility
 }
 
 type ViewerDraftRequest struct {
@@ -0,0 +1,13 @@
+package entities
+
+// RerenderNoModel is the reason revisions published before codelab models were stored are skipped.
+const RerenderNoModel = "no stored model"
+
+type RerenderResult struct {
+	FileId   string `json:"fileId"`
+	Revision int    `json:"revision"`
+	Size     int    `json:"size,omitempty"`
+	Skipped  bool   `json:"skipped,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
@@ -0,0 +1,27 @@
+package entities
+
+// Roles granted to emails or domains, each role includes the permissions of the roles before it.
+const (
+	RoleViewer   = "viewer"
+	RoleAuthor   = "author"
+	RoleReviewer = "reviewer"
+	RoleAdmin    = "admin"
+)
+
+var roleRanks = map[string]int{
+	RoleViewer:   1,
+	RoleAuthor:   2,
+	RoleReviewer: 3,
+	RoleAdmin:    4,
+}
+
+// ValidRole reports whether role is a known role.
+func ValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// RoleAllows reports whether role includes the permissions of required.
+func RoleAllows(role string, required string) bool {
+	return ValidRole(role) && roleRanks[role] >= roleRanks[required]
+}
@@ -19,6 +19,7 @@ type UserSession struct {
 	Name        string    `json:"name"`
 	UserId      string    `json:"userId"`
 	Email       string    `json:"email"`
+	Domain      string    `json:"domain,omitempty"`
 	State       string    `json:"state"`
 	Token       string    `json:"token"`
 	CreatedAt   time.Time `json:"createdAt"`
@@ -0,0 +1,14 @@
+package entities
+
+import "github.com/foxfoxio/codelabs-preview-go/internal/codelab"
+
+// Step is the structured content of a codelab step, for clients rendering codelabs themselves.
+type Step struct {
+	// Index is the 1-based position of the step
+	Index int    `json:"index"`
+	Title string `json:"title"`
+	// Duration is in minutes
+	Duration int           `json:"duration"`
+	Tags     []string      `json:"tags,omitempty"`
+	Content  *codelab.Node `json:"content"`
+}
@@ -0,0 +1,57 @@
+package entities
+
+import "strings"
+
+// Visibility modes of a published codelab.
+const (
+	VisibilityPublic    = "public"
+	VisibilityDomain    = "domain"
+	VisibilityAllowList = "allowlist"
+)
+
+// Visibility restricts who may view a published codelab, Domains apply to the domain mode and Emails to the
+// allow-list mode.
+type Visibility struct {
+	Mode    string   `json:"mode"`
+	Domains []string `json:"domains,omitempty"`
+	Emails  []string `json:"emails,omitempty"`
+}
+
+// Public reports whether anyone may view the codelab, codelabs without a visibility are public.
+func (v *Visibility) Public() bool {
+	return v == nil || v.Mode == "" || v.Mode == VisibilityPublic
+}
+
+// Allows reports whether the user of session may view the codelab.
+func (v *Visibility) Allows(session *UserSession) bool {
+	if v.Public() {
+		return true
+	}
+
+	if session == nil {
+		return false
+	}
+
+	switch v.Mode {
+	case VisibilityDomain:
+		return containsFold(v.Domains, session.Domain)
+	case VisibilityAllowList:
+		return containsFold(v.Emails, session.Email)
+	}
+
+	return false
+}
+
+func containsFold(values []string, value string) bool {
+	if value == "" {
+		return false
+	}
+
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -19,12 +19,28 @@ func createCodelabsRoutes(viewerEp endpoints.Viewer) routes {
 		// REST model
 		r("/{fileId}", viewerEp.Publish, "POST"),
 		r("/{fileId}", viewerEp.View, "GET"),
+		r("/{fileId}", viewerEp.Delete, "DELETE"),
 		r("/{fileId}/meta/latest", viewerEp.Meta, "GET"),
 		r("/{fileId}/meta/{revision}", viewerEp.Meta, "GET"),
 		r("/{fileId}/meta", viewerEp.Meta, "GET"),
 		r("/{fileId}/latest", viewerEp.View, "GET"),
 		r("/{fileId}/preview", viewerEp.Preview, "GET"),
+		r("/{fileId}/lint", viewerEp.Lint, "GET"),
+		r("/{fileId}/links", viewerEp.Links, "GET"),
+		r("/{fileId}/collaborators", viewerEp.Collaborators, "GET"),
+		r("/{fileId}/collaborators", viewerEp.AddCollaborator, "POST"),
+		r("/{fileId}/collaborators/{email}", viewerEp.RemoveCollaborator, "DELETE"),
+		r("/{fileId}/visibility", viewerEp.SetVisibility, "PUT"),
+		r("/{fileId}/revisions", viewerEp.Revisions, "GET"),
+		r("/{fileId}/revisions/{revision}/promote", viewerEp.Promote, "POST"),
+		r("/{fileId}/diff/{from}/{to}", viewerEp.Diff, "GET"),
+		r("/{fileId}/{revision}/img/{name}", viewerEp.Image, "GET"),
+		r("/{fileId}/{revision}/bundle.zip", viewerEp.Bundle, "GET"),
+		r("/{fileId}/{revision}/steps", viewerEp.Steps, "GET"),
+		r("/{fileId}/{revision}/steps/{step}", viewerEp.Steps, "GET"),
+		r("/{fileId}/{revision}/offline/{page}", viewerEp.View, "GET"),
 		r("/{fileId}/{revision}", viewerEp.View, "GET"),
+		r("/{fileId}/{revision}", viewerEp.Delete, "DELETE"),
 		r("/", viewerEp.Draft, "POST"),
 	}
 }
@@ -43,11 +59,21 @@ func createDraftRoutes(viewerEp endpoints.Viewer) routes {
 	}
 }
 
+func createAdminRoutes(viewerEp endpoints.Viewer) routes {
+	return routes{
+		r("/rerender", viewerEp.Rerender, "POST"),
+		r("/roles", viewerEp.Roles, "GET"),
+		r("/roles", viewerEp.GrantRole, "POST"),
+		r("/roles/{subject}", viewerEp.RevokeRole, "DELETE"),
+	}
+}
+
 func RegisterHttpRouter(router *mux.Router, authEp endpoints.AuthHttp, viewerEp endpoints.Viewer) {
 	authRoutes := createAuthRoutes(authEp)
 	rootRoutes := createRootRoutes(viewerEp)
 	draftRoutes := createDraftRoutes(viewerEp)
 	codeLabsRoutes := createCodelabsRoutes(viewerEp)
+	adminRoutes := createAdminRoutes(viewerEp)
 
 	authRouter := router.PathPrefix("/auth").Subrouter()
 	authRoutes.Build(authRouter)
@@ -58,6 +84,9 @@ func RegisterHttpRouter(router *mux.Router, authEp endpoints.AuthHttp, viewerEp
 	vRouter := router.PathPrefix("/v").Subrouter()
 	codeLabsRoutes.Build(vRouter)
 
+	adminRouter := router.PathPrefix("/admin").Subrouter()
+	adminRoutes.Build(adminRouter)
+
 	draftRouter := router.PathPrefix("/draft").Subrouter()
 	draftRoutes.Build(draftRouter)
 
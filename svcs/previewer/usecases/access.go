@@ -0,0 +1,163 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	cp "github.com/foxfoxio/codelabs-preview-go/internal"
+	"github.com/foxfoxio/codelabs-preview-go/internal/stopwatch"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities/requests"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrAccessControlDisabled = errors.New("access control disabled")
+	ErrInvalidRole           = errors.New("invalid role")
+	ErrInvalidSubject        = errors.New("invalid subject")
+)
+
+// subjectPattern matches the emails and domains roles are granted to.
+var subjectPattern = regexp.MustCompile(`^([^@\s]+@)?[a-z0-9.-]+\.[a-z]+$`)
+
+// authorize checks that the user of the session has the required role. Without a policy store access control
+// is disabled, every user being a reviewer, and only the admin email is an admin.
+func (uc *viewerUsecase) authorize(ctx context.Context, required string) error {
+	log := cp.Log(ctx, "ViewerUsecase.authorize").WithField("required", required)
+	session := getSession(ctx)
+
+	if session == nil {
+		if uc.policies == nil && required != entities.RoleAdmin {
+			return nil
+		}
+		log.Error("get user session failed")
+		return ErrUnauthorized
+	}
+
+	role, err := uc.sessionRole(ctx, session)
+	if err != nil {
+		log.WithError(err).Error("resolve role failed")
+		return err
+	}
+
+	if !entities.RoleAllows(role, required) {
+		log.WithField("email", session.Email).WithField("role", role).Error("role not allowed")
+		return ErrForbidden
+	}
+
+	return nil
+}
+
+// sessionRole returns the role of the user, the role granted to the email takes precedence over the role
+// granted to the domain, which takes precedence over the default role.
+func (uc *viewerUsecase) sessionRole(ctx context.Context, session *entities.UserSession) (string, error) {
+	if uc.adminEmail != "" && strings.EqualFold(session.Email, uc.adminEmail) {
+		return entities.RoleAdmin, nil
+	}
+
+	if uc.policies == nil {
+		return entities.RoleReviewer, nil
+	}
+
+	if session.Email != "" {
+		role, err := uc.policies.Role(ctx, session.Email)
+		if err != nil || role != "" {
+			return role, err
+		}
+	}
+
+	if session.Domain != "" {
+		role, err := uc.policies.Role(ctx, session.Domain)
+		if err != nil || role != "" {
+			return role, err
+		}
+	}
+
+	return uc.defaultRole, nil
+}
+
+func (uc *viewerUsecase) Roles(ctx context.Context, request *requests.ViewerRolesRequest) (*requests.ViewerRolesResponse, error) {
+	log := cp.Log(ctx, "ViewerUsecase.Roles")
+	defer stopwatch.StartWithLogger(log).Stop()
+
+	if err := uc.authorizePolicies(ctx); err != nil {
+		return nil, err
+	}
+
+	grants, err := uc.policies.List(ctx)
+	if err != nil {
+		log.WithError(err).Error("list grants failed")
+		return nil, err
+	}
+
+	return &requests.ViewerRolesResponse{Grants: grants, DefaultRole: uc.defaultRole}, nil
+}
+
+func (uc *viewerUsecase) GrantRole(ctx context.Context, request *requests.ViewerGrantRoleRequest) (*requests.ViewerGrantRoleResponse, error) {
+	log := cp.Log(ctx, "ViewerUsecase.GrantRole").WithField("subject", request.Subject).WithField("role", request.Role)
+	defer stopwatch.StartWithLogger(log).Stop()
+
+	if err := uc.authorizePolicies(ctx); err != nil {
+		return nil, err
+	}
+
+	subject := strings.ToLower(strings.TrimSpace(request.Subject))
+	if !subjectPattern.MatchString(subject) {
+		return nil, ErrInvalidSubject
+	}
+
+	if !entities.ValidRole(request.Role) {
+		return nil, ErrInvalidRole
+	}
+
+	if err := uc.policies.Grant(ctx, subject, request.Role); err != nil {
+		log.WithError(err).Error("grant role failed")
+		return nil, err
+	}
+
+	log.WithField("by", getSession(ctx).Email).Info("role granted")
+
+	return &requests.ViewerGrantRoleResponse{}, nil
+}
+
+func (uc *viewerUsecase) RevokeRole(ctx context.Context, request *requests.ViewerRevokeRoleRequest) (*requests.ViewerRevokeRoleResponse, error) {
+	log := cp.Log(ctx, "ViewerUsecase.RevokeRole").WithField("subject", request.Subject)
+	defer stopwatch.StartWithLogger(log).Stop()
+
+	if err := uc.authorizePolicies(ctx); err != nil {
+		return nil, err
+	}
+
+	subject := strings.ToLower(strings.TrimSpace(request.Subject))
+	role, err := uc.policies.Role(ctx, subject)
+	if err != nil {
+		log.WithError(err).Error("read role failed")
+		return nil, err
+	}
+
+	if role == "" {
+		return nil, ErrNotFound
+	}
+
+	if err := uc.policies.Revoke(ctx, subject); err != nil {
+		log.WithError(err).Error("revoke role failed")
+		return nil, err
+	}
+
+	log.WithField("by", getSession(ctx).Email).Info("role revoked")
+
+	return &requests.ViewerRevokeRoleResponse{}, nil
+}
+
+// authorizePolicies checks that the policies can be managed by the user of the session.
+func (uc *viewerUsecase) authorizePolicies(ctx context.Context) error {
+	if err := uc.authorize(ctx, entities.RoleAdmin); err != nil {
+		return err
+	}
+
+	if uc.policies == nil {
+		return ErrAccessControlDisabled
+	}
+
+	return nil
+}
@@ -2,6 +2,7 @@ package usecases
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	tokenUtils "github.com/foxfoxio/codelabs-preview-go/internal/token"
 	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities/requests"
@@ -9,25 +10,32 @@ import (
 	"time"
 )
 
+// ErrEmailNotVerified rejects tokens of accounts whose email is not verified, anyone can sign up with any email.
+var ErrEmailNotVerified = errors.New("email not verified")
+
 type Auth interface {
 	ProcessSession(ctx context.Context, request *requests.AuthProcessSessionRequest) (*requests.AuthProcessSessionResponse, error)
 	ProcessOauth2Callback(ctx context.Context, request *requests.AuthProcessOauth2CallbackRequest) (*requests.AuthProcessOauth2CallbackResponse, error)
 	ProcessFirebaseAuthorization(ctx context.Context, request *requests.AuthProcessFirebaseAuthorizationRequest) (*requests.AuthProcessFirebaseAuthorizationResponse, error)
 }
 
-func NewAuth(config *oauth2.Config) Auth {
+// NewAuth creates the auth usecase, firebase authorization tokens are verified with verifier.
+func NewAuth(config *oauth2.Config, verifier tokenUtils.Verifier) Auth {
 
 	return &authUsecase{
-		config: config,
+		config:   config,
+		verifier: verifier,
 	}
 }
 
 type authUsecase struct {
-	config *oauth2.Config
+	config   *oauth2.Config
+	verifier tokenUtils.Verifier
 }
 
 func (uc *authUsecase) ProcessSession(ctx context.Context, request *requests.AuthProcessSessionRequest) (*requests.AuthProcessSessionResponse, error) {
-	isValid := request.UserSession != nil && request.UserSession.IsValid()
+	// sessions signed in before emails were recorded sign in again
+	isValid := request.UserSession != nil && request.UserSession.IsValid() && request.UserSession.Email != ""
 	redirectUrl := ""
 	randState := ""
 
@@ -61,13 +69,17 @@ func (uc *authUsecase) ProcessOauth2Callback(ctx context.Context, request *reque
 
 	userId := ""
 	name := ""
+	email := ""
+	domain := ""
 	if rawIDToken, ok := token.Extra("id_token").(string); ok {
 		jwtClaim, e := tokenUtils.ExtractJwtClaims(rawIDToken)
 		if e != nil {
 			fmt.Println("extract jwt claim failed", e.Error())
-		} else {
+		} else if jwtClaim.EmailVerified {
 			userId = jwtClaim.Email
 			name = jwtClaim.Name
+			email = jwtClaim.Email
+			domain = jwtClaim.Domain()
 		}
 	}
 
@@ -80,20 +92,28 @@ func (uc *authUsecase) ProcessOauth2Callback(ctx context.Context, request *reque
 	return &requests.AuthProcessOauth2CallbackResponse{
 		Name:   name,
 		UserId: userId,
+		Email:  email,
+		Domain: domain,
 		Token:  encodedToken,
 	}, nil
 }
 
 func (uc *authUsecase) ProcessFirebaseAuthorization(ctx context.Context, request *requests.AuthProcessFirebaseAuthorizationRequest) (*requests.AuthProcessFirebaseAuthorizationResponse, error) {
-	// TODO: verify token with firebase
-	claim, err := tokenUtils.ExtractJwtClaims(request.AuthorizationToken)
+	claim, err := uc.verifier.Verify(ctx, request.AuthorizationToken)
 
 	if err != nil {
 		return nil, err
 	}
+
+	// roles, ownership and visibility are granted by email
+	if !claim.EmailVerified {
+		return nil, ErrEmailNotVerified
+	}
+
 	return &requests.AuthProcessFirebaseAuthorizationResponse{
 		UserId:    claim.UserId,
 		Email:     claim.Email,
+		Domain:    claim.Domain(),
 		ExpiresAt: claim.ExpiresAt(),
 	}, nil
 }
@@ -0,0 +1,37 @@
+package usecases
+
+import (
+	"context"
+	"github.com/foxfoxio/codelabs-preview-go/internal/token"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities/requests"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// fakeVerifier accepts the tokens it knows the claims of.
+type fakeVerifier map[string]*token.JwtClaims
+
+func (v fakeVerifier) Verify(ctx context.Context, rawToken string) (*token.JwtClaims, error) {
+	if claim, ok := v[rawToken]; ok {
+		return claim, nil
+	}
+	return nil, token.ErrInvalidToken
+}
+
+func TestAuthFirebaseAuthorization(t *testing.T) {
+	exp := int(time.Now().Add(time.Hour).Unix())
+	uc := NewAuth(nil, fakeVerifier{
+		"verified":   {UserId: "user-1", Email: "admin@example.com", EmailVerified: true, Exp: exp},
+		"unverified": {UserId: "user-2", Email: "admin@example.com", Hd: "example.com", Exp: exp},
+	})
+
+	res, err := uc.ProcessFirebaseAuthorization(context.Background(), &requests.AuthProcessFirebaseAuthorizationRequest{AuthorizationToken: "verified"})
+	assert.NoError(t, err)
+	assert.Equal(t, "admin@example.com", res.Email)
+	assert.Equal(t, "example.com", res.Domain)
+
+	// anyone can register an account with the admin email without verifying it
+	_, err = uc.ProcessFirebaseAuthorization(context.Background(), &requests.AuthProcessFirebaseAuthorizationRequest{AuthorizationToken: "unverified"})
+	assert.Equal(t, ErrEmailNotVerified, err)
+}
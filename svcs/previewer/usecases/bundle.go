@@ -0,0 +1,279 @@
+package usecases
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	cp "github.com/foxfoxio/codelabs-preview-go/internal"
+	"github.com/foxfoxio/codelabs-preview-go/internal/gstorage"
+	"github.com/foxfoxio/codelabs-preview-go/internal/stopwatch"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities/requests"
+	"github.com/googlecodelabs/tools/claat/types"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	maxAssetSize      = 10 << 20
+	maxCachedAssets   = 32
+	defaultBundleRoot = "codelab"
+)
+
+// bundleRootPattern matches the codelab ids usable as the directory of the bundle.
+var bundleRootPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// bundledTagPattern matches the tags loading the assets replaced by their copy in the bundle.
+var bundledTagPattern = regexp.MustCompile(`<(?:script|link)\b[^>]*>`)
+
+// subresourceAttributePattern matches the attributes checking the integrity of remote assets,
+// which do not apply to their copy in the bundle.
+var subresourceAttributePattern = regexp.MustCompile(`\s+(?:integrity|crossorigin)(?:="[^"]*")?`)
+
+type bundleAsset struct {
+	URL  string
+	Name string
+}
+
+// bundleAssets returns the files loaded by a codelab rendered with prefix, stored in the bundle under their name.
+func bundleAssets(prefix string) []*bundleAsset {
+	assets := []*bundleAsset{
+		{Name: "codelab-elements/codelab-elements.css"},
+		{Name: "codelab-elements/native-shim.js"},
+		{Name: "codelab-elements/custom-elements.min.js"},
+		{Name: "codelab-elements/codelab-elements.js"},
+	}
+
+	for _, asset := range assets {
+		asset.URL = prefix + "/" + asset.Name
+	}
+
+	return append(assets, &bundleAsset{URL: "https://cdnjs.cloudflare.com/ajax/libs/prism/1.22.0/prism.min.js", Name: "vendor/prism.min.js"})
+}
+
+// Bundle packages a revision with its images and the assets it loads into a zip that opens without network.
+// Assets are always downloaded from the prefix of the service, whatever the codelab renders with.
+// Web fonts and icons keep loading from google fonts and fall back to local fonts offline.
+// Revisions published before codelab models were stored cannot be bundled.
+func (uc *viewerUsecase) Bundle(ctx context.Context, request *requests.ViewerBundleRequest) (*requests.ViewerBundleResponse, error) {
+	log := cp.Log(ctx, "ViewerUsecase.Bundle").WithField("fileId", request.FileId).WithField("revision", request.Revision)
+	defer stopwatch.StartWithLogger(log).Stop()
+
+	if err := uc.authorizeView(ctx, request.FileId); err != nil {
+		return nil, err
+	}
+
+	revision, err := uc.resolveRevision(ctx, request.FileId, request.Revision)
+	if err != nil {
+		log.WithError(err).Error("resolve revision failed")
+		return nil, err
+	}
+
+	codelab, err := uc.readCodelab(ctx, request.FileId, revision)
+	if err != nil {
+		log.WithError(err).Error("read codelab failed")
+		return nil, err
+	}
+
+	root := bundleRoot(codelab.ID, request.FileId)
+
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+
+	images, err := uc.bundleImages(ctx, request.FileId, revision, codelab)
+	if err != nil {
+		log.WithError(err).Error("bundle images failed")
+		return nil, err
+	}
+
+	names := make([]string, 0, len(images))
+	for name := range images {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := writeZipFile(archive, path.Join(root, name), images[name]); err != nil {
+			return nil, err
+		}
+	}
+
+	var index bytes.Buffer
+	if err := uc.renderOutput(ctx, &index, codelab); err != nil {
+		log.WithError(err).Error("render codelab failed")
+		return nil, err
+	}
+
+	indexContent := index.String()
+	codelabPrefix := uc.codelabRenderContext(codelab).Prefix
+	bundled := make(map[string]bool)
+	for _, asset := range bundleAssets(uc.renderContext.Prefix) {
+		content, err := uc.readAsset(ctx, asset.URL)
+		if err != nil {
+			log.WithError(err).WithField("url", asset.URL).Error("download asset failed")
+			return nil, err
+		}
+
+		if err := writeZipFile(archive, path.Join(root, asset.Name), content); err != nil {
+			return nil, err
+		}
+
+		indexContent = strings.Replace(indexContent, asset.URL, asset.Name, -1)
+		indexContent = strings.Replace(indexContent, codelabPrefix+"/"+asset.Name, asset.Name, -1)
+		bundled[`"`+asset.Name+`"`] = true
+	}
+
+	indexContent = bundledTagPattern.ReplaceAllStringFunc(indexContent, func(tag string) string {
+		for name := range bundled {
+			if strings.Contains(tag, name) {
+				return subresourceAttributePattern.ReplaceAllString(tag, "")
+			}
+		}
+		return tag
+	})
+
+	if err := writeZipFile(archive, path.Join(root, indexFileName), []byte(indexContent)); err != nil {
+		return nil, err
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+
+	log.WithField("size", buffer.Len()).Info("bundle created")
+
+	return &requests.ViewerBundleResponse{
+		Name:    fmt.Sprintf("%s-%d.zip", root, revision),
+		Content: buffer.Bytes(),
+	}, nil
+}
+
+// bundleRoot returns the directory of the bundle, the codelab id when it is a safe file name.
+func bundleRoot(codelabId string, fileId string) string {
+	for _, root := range []string{codelabId, fileId} {
+		if bundleRootPattern.MatchString(root) {
+			return root
+		}
+	}
+
+	return defaultBundleRoot
+}
+
+// bundleImages points the images of the codelab at their copy in the bundle and returns the copies.
+// Stored images are read from the bucket, images of earlier revisions are downloaded.
+func (uc *viewerUsecase) bundleImages(ctx context.Context, fileId string, revision int, codelab *types.Codelab) (map[string][]byte, error) {
+	log := cp.Log(ctx, "ViewerUsecase.bundleImages").WithField("fileId", fileId).WithField("revision", revision)
+	storedPrefix := fmt.Sprintf("%s/%s/%d/%s/", imageURLPrefix, fileId, revision, imageDirName)
+
+	images := make(map[string][]byte)
+	bundled := make(map[string]string)
+	for _, step := range codelab.Steps {
+		for _, n := range types.ImageNodes(step.Content.Nodes) {
+			if src, ok := bundled[n.Src]; ok {
+				n.Src = src
+				continue
+			}
+
+			var content []byte
+			var name string
+
+			if strings.HasPrefix(n.Src, storedPrefix) {
+				name = strings.TrimPrefix(n.Src, storedPrefix)
+				b, err := uc.gStorageClient.Read(ctx, uc.imagePath(fileId, revision, name))
+				if err != nil {
+					if gstorage.IsNotExistError(err) {
+						log.WithField("src", n.Src).Warn("stored image not found, keeping original source")
+						continue
+					}
+					return nil, err
+				}
+				content = b.Bytes()
+			} else {
+				u, err := url.Parse(n.Src)
+				if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+					continue
+				}
+
+				b, ext, err := uc.downloadImage(ctx, u.String())
+				if err != nil {
+					log.WithError(err).WithField("src", n.Src).Warn("download image failed, keeping original source")
+					continue
+				}
+
+				sum := sha256.Sum256(b)
+				name = hex.EncodeToString(sum[:])[:imageHashNameSize] + ext
+				content = b
+			}
+
+			src := path.Join(imageDirName, name)
+			images[src] = content
+			bundled[n.Src] = src
+			n.Src = src
+		}
+	}
+
+	return images, nil
+}
+
+// readAsset downloads an asset loaded by the rendered codelab, the first assets downloaded are kept in memory.
+func (uc *viewerUsecase) readAsset(ctx context.Context, assetURL string) ([]byte, error) {
+	uc.assetsMu.Lock()
+	content, ok := uc.assets[assetURL]
+	uc.assetsMu.Unlock()
+
+	if ok {
+		return content, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, assetURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := uc.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+
+	content, err = ioutil.ReadAll(io.LimitReader(res.Body, maxAssetSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(content) > maxAssetSize {
+		return nil, errors.New("asset too large")
+	}
+
+	uc.assetsMu.Lock()
+	if len(uc.assets) < maxCachedAssets {
+		uc.assets[assetURL] = content
+	}
+	uc.assetsMu.Unlock()
+
+	return content, nil
+}
+
+func writeZipFile(archive *zip.Writer, name string, content []byte) error {
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(content)
+	return err
+}
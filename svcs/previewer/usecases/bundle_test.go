@@ -0,0 +1,151 @@
+package usecases
+
+import (
+	"archive/zip"
+	"bytes"
+	"github.com/foxfoxio/codelabs-preview-go/internal/gstorage"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities/requests"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeTransport serves the content of urls, anything else is not found.
+type fakeTransport struct {
+	urls      map[string]string
+	requests  int32
+	mu        sync.Mutex
+	requested []string
+}
+
+func (t *fakeTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&t.requests, 1)
+	t.mu.Lock()
+	t.requested = append(t.requested, r.URL.String())
+	t.mu.Unlock()
+
+	content, ok := t.urls[r.URL.String()]
+	status := http.StatusOK
+	if !ok {
+		status = http.StatusNotFound
+	}
+
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader(content)),
+		Request:    r,
+	}, nil
+}
+
+func readZip(t *testing.T, content []byte) map[string]string {
+	archive, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	assert.NoError(t, err)
+
+	files := make(map[string]string)
+	for _, f := range archive.File {
+		r, err := f.Open()
+		assert.NoError(t, err)
+		b, err := ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		files[f.Name] = string(b)
+	}
+
+	return files
+}
+
+func TestViewerBundle(t *testing.T) {
+	transport := &fakeTransport{urls: map[string]string{
+		"https://example.com/image.gif": "GIF89a fake image",
+	}}
+	for _, asset := range bundleAssets(defaultRenderPrefix) {
+		transport.urls[asset.URL] = "/* " + asset.Name + " */"
+	}
+
+	ctx := authorContext()
+	uc := newTestViewer(gstorage.NewMemoryClient())
+	uc.httpClient = &http.Client{Transport: transport}
+	uc.driveClient.(*fakeDriveClient).docs["img"] = strings.Replace(testCodelabDoc, "<p><span>hello world</span></p>",
+		`<p><img src="https://example.com/image.gif"></p>`, 1)
+
+	_, err := uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "img"})
+	assert.NoError(t, err)
+
+	res, err := uc.Bundle(ctx, &requests.ViewerBundleRequest{FileId: "img"})
+	assert.NoError(t, err)
+	assert.Equal(t, "test-codelab-1.zip", res.Name)
+
+	files := readZip(t, res.Content)
+
+	index := files["test-codelab/index.html"]
+	assert.NotContains(t, index, defaultRenderPrefix)
+	assert.NotContains(t, index, "/v/img/")
+	for _, asset := range bundleAssets(defaultRenderPrefix) {
+		assert.Contains(t, index, `"`+asset.Name+`"`)
+		assert.Equal(t, "/* "+asset.Name+" */", files["test-codelab/"+asset.Name])
+	}
+
+	images := 0
+	for name, content := range files {
+		if strings.HasPrefix(name, "test-codelab/img/") {
+			images++
+			assert.Equal(t, "GIF89a fake image", content)
+			assert.Contains(t, index, strings.TrimPrefix(name, "test-codelab/"))
+		}
+	}
+	assert.Equal(t, 1, images)
+
+	// assets are downloaded once
+	downloads := atomic.LoadInt32(&transport.requests)
+	_, err = uc.Bundle(ctx, &requests.ViewerBundleRequest{FileId: "img", Revision: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, downloads, atomic.LoadInt32(&transport.requests))
+}
+
+func TestViewerBundleUntrustedMetadata(t *testing.T) {
+	transport := &fakeTransport{urls: map[string]string{}}
+	for _, asset := range bundleAssets(defaultRenderPrefix) {
+		transport.urls[asset.URL] = "/* " + asset.Name + " */"
+	}
+
+	ctx := authorContext()
+	uc := newTestViewer(gstorage.NewMemoryClient())
+	uc.httpClient = &http.Client{Transport: transport}
+
+	markdown := strings.Replace(testCodelabMarkdown, "id: markdown-codelab", "id: ../../evil", 1)
+	markdown = "prefix: http://169.254.169.254/computeMetadata\n" + markdown
+	_, err := uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "md", Markdown: []byte(markdown)})
+	assert.NoError(t, err)
+
+	res, err := uc.Bundle(ctx, &requests.ViewerBundleRequest{FileId: "md"})
+	assert.NoError(t, err)
+	assert.NotContains(t, res.Name, "..")
+
+	files := readZip(t, res.Content)
+	for name := range files {
+		assert.NotContains(t, name, "..")
+	}
+
+	// assets come from the prefix of the service
+	for _, u := range transport.requested {
+		assert.NotContains(t, u, "169.254.169.254")
+	}
+
+	index := files[strings.TrimSuffix(res.Name, "-1.zip")+"/index.html"]
+	assert.NotContains(t, index, "169.254.169.254")
+	assert.Contains(t, index, `src="vendor/prism.min.js"`)
+	assert.NotContains(t, index, "integrity=")
+	assert.NotContains(t, index, "crossorigin=")
+}
+
+func TestBundleRoot(t *testing.T) {
+	assert.Equal(t, "test-codelab", bundleRoot("test-codelab", "doc"))
+	assert.Equal(t, "doc", bundleRoot("", "doc"))
+	assert.Equal(t, "doc", bundleRoot("../../evil", "doc"))
+	assert.Equal(t, "doc", bundleRoot("a/b", "doc"))
+	assert.Equal(t, defaultBundleRoot, bundleRoot("..", ".."))
+}
@@ -0,0 +1,73 @@
+package usecases
+
+import (
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities"
+	"github.com/googlecodelabs/tools/claat/types"
+	"strings"
+	"time"
+)
+
+const readingWordsPerMinute = 200
+
+// summarizeContent fills the table of contents and content counts of meta from the codelab.
+func summarizeContent(meta *entities.Meta, codelab *types.Codelab) {
+	meta.Toc = make([]*entities.TocEntry, 0, len(codelab.Steps))
+	meta.WordCount, meta.CodeBlocks, meta.Images = 0, 0, 0
+
+	for i, step := range codelab.Steps {
+		meta.Toc = append(meta.Toc, &entities.TocEntry{
+			Index:    i + 1,
+			Title:    step.Title,
+			Duration: int(step.Duration / time.Minute),
+		})
+
+		meta.WordCount += len(strings.Fields(step.Title))
+		if step.Content == nil {
+			continue
+		}
+
+		walkNodes(step.Content.Nodes, func(n types.Node) {
+			switch n := n.(type) {
+			case *types.TextNode:
+				meta.WordCount += len(strings.Fields(n.Value))
+			case *types.CodeNode:
+				meta.CodeBlocks++
+			case *types.ImageNode:
+				meta.Images++
+			}
+		})
+	}
+
+	// reading time is rounded up, a codelab with any text takes at least a minute
+	meta.ReadingTime = (meta.WordCount + readingWordsPerMinute - 1) / readingWordsPerMinute
+}
+
+// walkNodes calls fn for every node of nodes and their children, parents first.
+func walkNodes(nodes []types.Node, fn func(n types.Node)) {
+	for _, n := range nodes {
+		fn(n)
+
+		switch n := n.(type) {
+		case *types.ListNode:
+			walkNodes(n.Nodes, fn)
+		case *types.ItemsListNode:
+			for _, i := range n.Items {
+				walkNodes(i.Nodes, fn)
+			}
+		case *types.HeaderNode:
+			walkNodes(n.Content.Nodes, fn)
+		case *types.URLNode:
+			walkNodes(n.Content.Nodes, fn)
+		case *types.ButtonNode:
+			walkNodes(n.Content.Nodes, fn)
+		case *types.InfoboxNode:
+			walkNodes(n.Content.Nodes, fn)
+		case *types.GridNode:
+			for _, r := range n.Rows {
+				for _, c := range r {
+					walkNodes(c.Content.Nodes, fn)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package usecases
+
+import (
+	"bytes"
+	"context"
+	cp "github.com/foxfoxio/codelabs-preview-go/internal"
+	"github.com/foxfoxio/codelabs-preview-go/internal/diff"
+	"github.com/foxfoxio/codelabs-preview-go/internal/gstorage"
+	"github.com/foxfoxio/codelabs-preview-go/internal/stopwatch"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities/requests"
+	"golang.org/x/net/html"
+	htmlTemplate "html/template"
+	"io"
+	"strings"
+)
+
+// codelabStep is the comparable content of a step of a rendered codelab.
+type codelabStep struct {
+	Title string
+	Text  []string
+	Code  []string
+}
+
+// textElements are the elements whose text is compared as a single line.
+var textElements = map[string]bool{
+	"p": true, "li": true, "td": true, "th": true, "dt": true, "dd": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+}
+
+var diffTemplate = htmlTemplate.Must(htmlTemplate.New("diff").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="UTF-8" />
+<title>{{.FileId}}: revision {{.From}} to {{.To}}</title>
+<style>
+  body { font-family: Roboto, sans-serif; margin: 2em; }
+  .step { border: 1px solid #ddd; margin-bottom: 1em; padding: 0 1em 1em; }
+  .step.unchanged { color: #888; }
+  .status { font-size: small; text-transform: uppercase; color: #888; }
+  .line { white-space: pre-wrap; margin: 0; padding: 0 .5em; }
+  .code .line { font-family: "Roboto Mono", monospace; }
+  .insert { background: #e6f4ea; }
+  .insert::before { content: "+ "; }
+  .delete { background: #fce8e6; text-decoration: line-through; }
+  .delete::before { content: "- "; }
+</style>
+</head>
+<body>
+<h1>{{.FileId}}: revision {{.From}} to {{.To}}</h1>
+{{range .Steps}}
+<div class="step {{.Status}}">
+  <h2>{{range .Title}}<span class="{{.Op}}">{{.Text}}</span> {{end}}<span class="status">{{.Status}}</span></h2>
+  {{if ne .Status "unchanged"}}
+  <div class="text">{{range .Text}}<p class="line {{.Op}}">{{.Text}}</p>{{end}}</div>
+  {{if .Code}}<div class="code">{{range .Code}}<p class="line {{.Op}}">{{.Text}}</p>{{end}}</div>{{end}}
+  {{end}}
+</div>
+{{end}}
+</body>
+</html>
+`))
+
+func (uc *viewerUsecase) Diff(ctx context.Context, request *requests.ViewerDiffRequest) (*requests.ViewerDiffResponse, error) {
+	log := cp.Log(ctx, "ViewerUsecase.Diff").WithField("fileId", request.FileId).WithField("from", request.From).WithField("to", request.To)
+	defer stopwatch.StartWithLogger(log).Stop()
+
+	if err := uc.authorizeView(ctx, request.FileId); err != nil {
+		return nil, err
+	}
+
+	from, fromSteps, err := uc.readSteps(ctx, request.FileId, request.From)
+	if err != nil {
+		log.WithError(err).Error("read from revision failed")
+		return nil, err
+	}
+
+	to, toSteps, err := uc.readSteps(ctx, request.FileId, request.To)
+	if err != nil {
+		log.WithError(err).Error("read to revision failed")
+		return nil, err
+	}
+
+	steps := diffSteps(fromSteps, toSteps)
+
+	var buffer bytes.Buffer
+	err = diffTemplate.Execute(&buffer, map[string]interface{}{
+		"FileId": request.FileId,
+		"From":   from,
+		"To":     to,
+		"Steps":  steps,
+	})
+
+	if err != nil {
+		log.WithError(err).Error("render diff failed")
+		return nil, err
+	}
+
+	return &requests.ViewerDiffResponse{
+		From:     from,
+		To:       to,
+		Steps:    steps,
+		Response: buffer.String(),
+	}, nil
+}
+
+// readSteps reads the stored index of the revision and extracts its steps.
+func (uc *viewerUsecase) readSteps(ctx context.Context, fileId string, revision int) (int, []*codelabStep, error) {
+	revision, err := uc.resolveRevision(ctx, fileId, revision)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	indexBytes, err := uc.gStorageClient.Read(ctx, uc.objectPath(fileId, revision, indexFileName))
+	if err != nil {
+		if gstorage.IsNotExistError(err) {
+			return 0, nil, ErrNotFound
+		}
+		return 0, nil, err
+	}
+
+	steps, err := parseSteps(indexBytes)
+	return revision, steps, err
+}
+
+func diffSteps(from []*codelabStep, to []*codelabStep) []*entities.StepDiff {
+	count := len(from)
+	if len(to) > count {
+		count = len(to)
+	}
+
+	steps := make([]*entities.StepDiff, 0, count)
+	for i := 0; i < count; i++ {
+		a, b := &codelabStep{}, &codelabStep{}
+		status := ""
+
+		switch {
+		case i >= len(from):
+			b = to[i]
+			status = entities.StepDiffStatusAdded
+		case i >= len(to):
+			a = from[i]
+			status = entities.StepDiffStatusRemoved
+		default:
+			a, b = from[i], to[i]
+		}
+
+		step := &entities.StepDiff{
+			Index:  i + 1,
+			Status: status,
+			Title:  diff.Lines(nonEmpty(a.Title), nonEmpty(b.Title)),
+			Text:   diff.Lines(a.Text, b.Text),
+			Code:   diff.Lines(a.Code, b.Code),
+		}
+
+		if step.Status == "" {
+			step.Status = entities.StepDiffStatusUnchanged
+			if diff.Changed(step.Title) || diff.Changed(step.Text) || diff.Changed(step.Code) {
+				step.Status = entities.StepDiffStatusChanged
+			}
+		}
+
+		steps = append(steps, step)
+	}
+
+	return steps
+}
+
+// parseSteps extracts the steps of a codelab rendered by the claat html template.
+func parseSteps(r io.Reader) ([]*codelabStep, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	steps := make([]*codelabStep, 0)
+
+	var walk func(n *html.Node, step *codelabStep)
+	walk = func(n *html.Node, step *codelabStep) {
+		if n.Type == html.ElementNode {
+			switch {
+			case n.Data == "google-codelab-step":
+				step = &codelabStep{Title: nodeAttr(n, "label")}
+				steps = append(steps, step)
+			case step != nil && n.Data == "pre":
+				step.Code = append(step.Code, strings.Split(strings.TrimRight(nodeText(n), "\n"), "\n")...)
+				return
+			case step != nil && textElements[n.Data]:
+				if text := strings.Join(strings.Fields(nodeText(n)), " "); text != "" {
+					step.Text = append(step.Text, text)
+				}
+				return
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c, step)
+		}
+	}
+	walk(doc, nil)
+
+	return steps, nil
+}
+
+func nodeAttr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+
+	return ""
+}
+
+func nodeText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sb.WriteString(nodeText(c))
+	}
+
+	return sb.String()
+}
+
+func nonEmpty(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	return []string{s}
+}
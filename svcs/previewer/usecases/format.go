@@ -0,0 +1,226 @@
+package usecases
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	cp "github.com/foxfoxio/codelabs-preview-go/internal"
+	"github.com/foxfoxio/codelabs-preview-go/internal/gstorage"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities"
+	"github.com/googlecodelabs/tools/claat/render"
+	"github.com/googlecodelabs/tools/claat/types"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported format")
+
+type outputFormat struct {
+	Template    string
+	Ext         string
+	ContentType string
+	// MultiPage formats render every step to its own page
+	MultiPage bool
+}
+
+var outputFormats = map[string]*outputFormat{
+	entities.FormatHTML:     {Template: "html", Ext: ".html", ContentType: "text/html; charset=utf-8"},
+	entities.FormatMarkdown: {Template: "md", Ext: ".md", ContentType: "text/markdown; charset=utf-8"},
+	entities.FormatOffline:  {Template: "offline", Ext: ".html", ContentType: "text/html; charset=utf-8", MultiPage: true},
+	entities.FormatQwiklabs: {Template: "md", Ext: ".md", ContentType: "text/markdown; charset=utf-8"},
+	entities.FormatDevsite:  {Template: "devsite", Ext: ".html", ContentType: "text/html; charset=utf-8"},
+}
+
+// lookupFormat returns the output format, html when format is empty.
+func lookupFormat(format string) (string, *outputFormat, error) {
+	if format == "" {
+		format = entities.FormatHTML
+	}
+
+	output, ok := outputFormats[format]
+	if !ok {
+		return "", nil, ErrUnsupportedFormat
+	}
+
+	return format, output, nil
+}
+
+// renderFormat renders the codelab with the template of format, step is the page of multi page formats starting at 1.
+func (uc *viewerUsecase) renderFormat(ctx context.Context, w io.Writer, codelabs *types.Codelab, format string, step int) error {
+	format, output, err := lookupFormat(format)
+	if err != nil {
+		return err
+	}
+
+	rc := uc.codelabRenderContext(codelabs)
+
+	prefix := rc.Prefix
+	if output.MultiPage {
+		// the offline template appends its paths to the prefix
+		prefix += "/"
+	}
+
+	data := &struct {
+		render.Context
+		Current *types.Step
+		StepNum int
+		Prev    bool
+		Next    bool
+	}{Context: render.Context{
+		Env:      rc.Env,
+		Prefix:   prefix,
+		Format:   format,
+		GlobalGA: codelabs.GA,
+		Updated:  time.Now().Format(time.RFC3339),
+		Meta:     &codelabs.Meta,
+		Steps:    codelabs.Steps,
+		Extra:    rc.Extra,
+	}}
+
+	if output.MultiPage {
+		if step < 1 || step > len(codelabs.Steps) {
+			return ErrNotFound
+		}
+
+		data.Current = codelabs.Steps[step-1]
+		data.StepNum = step
+		data.Prev = step > 1
+		data.Next = step < len(codelabs.Steps)
+	}
+
+	if format == entities.FormatHTML && rc.Theme != "" {
+		return uc.renderTheme(ctx, w, rc.Theme, data.Context)
+	}
+
+	return render.Execute(w, output.Template, data)
+}
+
+// renderPages renders every page of the codelab in format, keyed by page name.
+func (uc *viewerUsecase) renderPages(ctx context.Context, codelabs *types.Codelab, format string) (map[string][]byte, error) {
+	format, output, err := lookupFormat(format)
+	if err != nil {
+		return nil, err
+	}
+
+	steps := 1
+	if output.MultiPage {
+		steps = len(codelabs.Steps)
+	}
+
+	pages := make(map[string][]byte, steps)
+	for step := 1; step <= steps; step++ {
+		var buffer bytes.Buffer
+		if err := uc.renderFormat(ctx, &buffer, codelabs, format, step); err != nil {
+			return nil, err
+		}
+		pages[pageName(output, step)] = buffer.Bytes()
+	}
+
+	return pages, nil
+}
+
+// pageName follows the page links of the claat offline template.
+func pageName(output *outputFormat, step int) string {
+	if output.MultiPage && step > 1 {
+		return fmt.Sprintf("step-%d%s", step, output.Ext)
+	}
+
+	return "index" + output.Ext
+}
+
+// pageStep returns the step rendered to page, the index page when page is empty.
+func pageStep(output *outputFormat, page string) (int, bool) {
+	if page == "" || page == pageName(output, 1) {
+		return 1, true
+	}
+
+	if !output.MultiPage || !strings.HasPrefix(page, "step-") || !strings.HasSuffix(page, output.Ext) {
+		return 0, false
+	}
+
+	step, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(page, "step-"), output.Ext))
+	if err != nil || step < 2 {
+		return 0, false
+	}
+
+	return step, true
+}
+
+// viewFormat serves a page of the revision in format, rendering every page of the format
+// from the stored codelab model the first time it is requested.
+func (uc *viewerUsecase) viewFormat(ctx context.Context, fileId string, revision int, format string, page string, rerender bool) ([]byte, error) {
+	log := cp.Log(ctx, "ViewerUsecase.viewFormat").WithField("fileId", fileId).WithField("revision", revision).WithField("format", format)
+
+	format, output, err := lookupFormat(format)
+	if err != nil {
+		return nil, err
+	}
+
+	step, ok := pageStep(output, page)
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	name := pageName(output, step)
+	pagePath := uc.objectPath(fileId, revision, path.Join(format, name))
+
+	if !rerender {
+		pageBytes, err := uc.gStorageClient.Read(ctx, pagePath)
+		if err == nil {
+			return pageBytes.Bytes(), nil
+		}
+
+		if !gstorage.IsNotExistError(err) {
+			log.WithError(err).WithField("path", pagePath).Error("read page file failed")
+			return nil, err
+		}
+	}
+
+	codelab, err := uc.readCodelab(ctx, fileId, revision)
+	if err != nil {
+		log.WithError(err).Error("read codelab failed")
+		return nil, err
+	}
+
+	pages, err := uc.renderPages(ctx, codelab, format)
+	if err != nil {
+		log.WithError(err).Error("render pages failed")
+		return nil, err
+	}
+
+	pageBytes, ok := pages[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	for pageName, content := range pages {
+		p := uc.objectPath(fileId, revision, path.Join(format, pageName))
+		if _, err := uc.gStorageClient.Write(ctx, p, bytes.NewBuffer(content)); err != nil {
+			log.WithError(err).WithField("path", p).Error("write page file failed")
+			return nil, err
+		}
+	}
+
+	log.WithField("pages", len(pages)).Info("format stored")
+
+	return pageBytes, nil
+}
+
+// deleteFormats removes the stored pages of every format of the revision, they are rendered again when requested.
+func (uc *viewerUsecase) deleteFormats(ctx context.Context, fileId string, revision int) error {
+	for format := range outputFormats {
+		if format == entities.FormatHTML {
+			continue
+		}
+
+		if err := uc.deleteObjects(ctx, uc.objectPath(fileId, revision, format)+"/"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
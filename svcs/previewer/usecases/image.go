@@ -0,0 +1,201 @@
+package usecases
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	cp "github.com/foxfoxio/codelabs-preview-go/internal"
+	"github.com/foxfoxio/codelabs-preview-go/internal/gstorage"
+	"github.com/foxfoxio/codelabs-preview-go/internal/stopwatch"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities/requests"
+	"github.com/googlecodelabs/tools/claat/types"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+)
+
+const (
+	imageDirName      = "img"
+	maxImageSize      = 20 << 20
+	defaultImageType  = "application/octet-stream"
+	imageURLPrefix    = "/v"
+	imageHashNameSize = 16
+)
+
+// imageExtensions maps the content types of downloaded images to the extension of their stored copy.
+var imageExtensions = map[string]string{
+	"image/png":     ".png",
+	"image/jpeg":    ".jpg",
+	"image/gif":     ".gif",
+	"image/webp":    ".webp",
+	"image/svg+xml": ".svg",
+	"image/bmp":     ".bmp",
+}
+
+var imageNamePattern = regexp.MustCompile(`^[0-9a-f]+\.[a-z]+$`)
+
+// storeImages downloads the remote images of the codelab into the revision and points them at the stored copies.
+// Images that cannot be downloaded keep their original source.
+func (uc *viewerUsecase) storeImages(ctx context.Context, fileId string, revision int, codelab *types.Codelab) error {
+	log := cp.Log(ctx, "ViewerUsecase.storeImages").WithField("fileId", fileId).WithField("revision", revision)
+
+	stored := make(map[string]string)
+	for _, step := range codelab.Steps {
+		for _, n := range types.ImageNodes(step.Content.Nodes) {
+			if src, ok := stored[n.Src]; ok {
+				n.Src = src
+				continue
+			}
+
+			u, err := url.Parse(n.Src)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				continue
+			}
+
+			b, ext, err := uc.downloadImage(ctx, u.String())
+			if err != nil {
+				log.WithError(err).WithField("src", n.Src).Warn("download image failed, keeping original source")
+				continue
+			}
+
+			sum := sha256.Sum256(b)
+			name := hex.EncodeToString(sum[:])[:imageHashNameSize] + ext
+			imagePath := uc.imagePath(fileId, revision, name)
+
+			if _, err := uc.gStorageClient.Write(ctx, imagePath, bytes.NewBuffer(b)); err != nil {
+				log.WithError(err).WithField("path", imagePath).Error("write image file failed")
+				return err
+			}
+
+			src := fmt.Sprintf("%s/%s/%d/%s/%s", imageURLPrefix, fileId, revision, imageDirName, name)
+			stored[n.Src] = src
+			n.Src = src
+		}
+	}
+
+	log.WithField("images", len(stored)).Info("images stored")
+
+	return nil
+}
+
+// downloadImage returns the content of the image and the extension matching its type.
+func (uc *viewerUsecase) downloadImage(ctx context.Context, src string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	res, err := uc.httpClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+
+	if res.ContentLength > maxImageSize {
+		return nil, "", errors.New("image too large")
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, maxImageSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(b) > maxImageSize {
+		return nil, "", errors.New("image too large")
+	}
+
+	contentType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if ext, ok := imageExtensions[contentType]; ok {
+		return b, ext, nil
+	}
+
+	contentType, _, _ = mime.ParseMediaType(http.DetectContentType(b))
+	if ext, ok := imageExtensions[contentType]; ok {
+		return b, ext, nil
+	}
+
+	return nil, "", fmt.Errorf("unsupported image type %q", contentType)
+}
+
+func (uc *viewerUsecase) imagePath(fileId string, revision int, name string) string {
+	return uc.objectPath(fileId, revision, path.Join(imageDirName, name))
+}
+
+// deleteImages removes the stored images of the revision.
+func (uc *viewerUsecase) deleteImages(ctx context.Context, fileId string, revision int) error {
+	return uc.deleteObjects(ctx, uc.imagePath(fileId, revision, "")+"/")
+}
+
+// deleteObjects removes every object under prefix.
+func (uc *viewerUsecase) deleteObjects(ctx context.Context, prefix string) error {
+	objects, err := uc.gStorageClient.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+
+	for _, o := range objects {
+		if err := uc.gStorageClient.Delete(ctx, o.Name); err != nil && !gstorage.IsNotExistError(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (uc *viewerUsecase) Image(ctx context.Context, request *requests.ViewerImageRequest) (*requests.ViewerImageResponse, error) {
+	log := cp.Log(ctx, "ViewerUsecase.Image").WithField("fileId", request.FileId).WithField("revision", request.Revision).WithField("name", request.Name)
+	defer stopwatch.StartWithLogger(log).Stop()
+
+	if !imageNamePattern.MatchString(request.Name) {
+		return nil, ErrNotFound
+	}
+
+	visibility, err := uc.readVisibility(ctx, request.FileId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := uc.authorizeVisibility(ctx, request.FileId, visibility); err != nil {
+		return nil, err
+	}
+
+	revision, err := uc.resolveRevision(ctx, request.FileId, request.Revision)
+	if err != nil {
+		log.WithError(err).Error("resolve revision failed")
+		return nil, err
+	}
+
+	imagePath := uc.imagePath(request.FileId, revision, request.Name)
+	imageBytes, err := uc.gStorageClient.Read(ctx, imagePath)
+
+	if err != nil {
+		log.WithError(err).WithField("path", imagePath).Error("read image file failed")
+		if gstorage.IsNotExistError(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(request.Name))
+	if contentType == "" {
+		contentType = defaultImageType
+	}
+
+	return &requests.ViewerImageResponse{
+		Content:     imageBytes.Bytes(),
+		ContentType: contentType,
+		Restricted:  !visibility.Public(),
+	}, nil
+}
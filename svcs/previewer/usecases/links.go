@@ -0,0 +1,118 @@
+package usecases
+
+import (
+	"context"
+	cp "github.com/foxfoxio/codelabs-preview-go/internal"
+	"github.com/foxfoxio/codelabs-preview-go/internal/stopwatch"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities/requests"
+	"github.com/googlecodelabs/tools/claat/types"
+	"net/url"
+	"time"
+)
+
+const (
+	linkCheckConcurrency  = 8
+	linkCheckHostInterval = 100 * time.Millisecond
+)
+
+func (uc *viewerUsecase) Links(ctx context.Context, request *requests.ViewerLinksRequest) (*requests.ViewerLinksResponse, error) {
+	log := cp.Log(ctx, "ViewerUsecase.Links").WithField("fileId", request.FileId)
+	defer stopwatch.StartWithLogger(log).Stop()
+
+	if getSession(ctx) == nil {
+		log.Error("get user session failed")
+		return nil, ErrUnauthorized
+	}
+
+	if err := uc.authorize(ctx, entities.RoleViewer); err != nil {
+		return nil, err
+	}
+
+	if err := uc.authorizeFile(ctx, request.FileId, claimNone); err != nil {
+		return nil, err
+	}
+
+	if err := uc.authorizeView(ctx, request.FileId); err != nil {
+		return nil, err
+	}
+
+	_, _, codelab, err := uc.parseCodeLabs(ctx, request.FileId)
+	if codelab == nil {
+		log.WithError(err).Error("parse codelab failed")
+		return nil, err
+	}
+
+	links := uc.checkLinks(ctx, codelab)
+	broken := len(brokenLinks(links))
+	log.WithField("links", len(links)).WithField("broken", broken).Info("links checked")
+
+	return &requests.ViewerLinksResponse{
+		Links:  links,
+		Broken: broken,
+	}, nil
+}
+
+// checkLinks checks the links, images and iframes of every step, a url found in several steps is requested once.
+// Links to addresses which are not public are reported broken without being requested.
+func (uc *viewerUsecase) checkLinks(ctx context.Context, codelab *types.Codelab) []*entities.LinkResult {
+	links := make([]*entities.LinkResult, 0)
+	urls := make([]string, 0)
+	for i, step := range codelab.Steps {
+		if step.Content == nil {
+			continue
+		}
+
+		seen := map[string]bool{}
+		for _, link := range linkNodes(step.Content.Nodes) {
+			u, err := url.Parse(link)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || seen[link] {
+				continue
+			}
+
+			seen[link] = true
+			links = append(links, &entities.LinkResult{Step: i + 1, URL: link})
+			urls = append(urls, link)
+		}
+	}
+
+	results := uc.linkChecker.Check(ctx, urls)
+	for _, link := range links {
+		result := results[link.URL]
+		link.Status = result.Status
+		link.Broken = result.Broken()
+		if result.Err != nil {
+			link.Error = result.Err.Error()
+		}
+	}
+
+	return links
+}
+
+func brokenLinks(links []*entities.LinkResult) []*entities.LinkResult {
+	broken := make([]*entities.LinkResult, 0)
+	for _, link := range links {
+		if link.Broken {
+			broken = append(broken, link)
+		}
+	}
+
+	return broken
+}
+
+// linkNodes returns the urls of the links, images and iframes of nodes, recursively.
+func linkNodes(nodes []types.Node) []string {
+	var links []string
+	walkNodes(nodes, func(n types.Node) {
+		switch n := n.(type) {
+		case *types.URLNode:
+			links = append(links, n.URL)
+		case *types.ImageNode:
+			links = append(links, n.Src)
+		case *types.IframeNode:
+			links = append(links, n.URL)
+		}
+	})
+
+	return links
+}
@@ -0,0 +1,252 @@
+package usecases
+
+import (
+	"context"
+	"fmt"
+	cp "github.com/foxfoxio/codelabs-preview-go/internal"
+	"github.com/foxfoxio/codelabs-preview-go/internal/stopwatch"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities/requests"
+	"github.com/googlecodelabs/tools/claat/types"
+	"strings"
+)
+
+// stepHeaderLevel is the level of the first headers inside a step, the step title being the level above.
+const stepHeaderLevel = 2
+
+type lintRule struct {
+	Name     string
+	Severity string
+	Check    func(config *entities.LintConfig, codelab *types.Codelab) []*entities.LintIssue
+}
+
+// lintRules are the rules codelabs are checked against, with their default severity.
+var lintRules = []*lintRule{
+	{Name: entities.LintRuleMetadata, Severity: entities.LintSeverityError, Check: lintMetadata},
+	{Name: entities.LintRuleFeedback, Severity: entities.LintSeverityWarning, Check: lintFeedback},
+	{Name: entities.LintRuleSteps, Severity: entities.LintSeverityError, Check: lintSteps},
+	{Name: entities.LintRuleEmptyStep, Severity: entities.LintSeverityError, Check: lintEmptySteps},
+	{Name: entities.LintRuleDuration, Severity: entities.LintSeverityWarning, Check: lintDurations},
+	{Name: entities.LintRuleImageAlt, Severity: entities.LintSeverityWarning, Check: lintImageAlts},
+	{Name: entities.LintRuleHeadingLevel, Severity: entities.LintSeverityWarning, Check: lintHeadingLevels},
+}
+
+var defaultLintMetadata = []string{
+	requests.ViewerDraftKeyTitle,
+	requests.ViewerDraftKeySummary,
+	requests.ViewerDraftKeySlug,
+}
+
+// newLintConfig fills the configuration left out with the defaults.
+func newLintConfig(config *entities.LintConfig) *entities.LintConfig {
+	c := &entities.LintConfig{Rules: map[string]string{}, Metadata: defaultLintMetadata, MinSteps: 1}
+	if config == nil {
+		return c
+	}
+
+	for rule, severity := range config.Rules {
+		c.Rules[rule] = severity
+	}
+
+	if config.Metadata != nil {
+		c.Metadata = config.Metadata
+	}
+
+	if config.MinSteps > 0 {
+		c.MinSteps = config.MinSteps
+	}
+
+	return c
+}
+
+func (uc *viewerUsecase) Lint(ctx context.Context, request *requests.ViewerLintRequest) (*requests.ViewerLintResponse, error) {
+	log := cp.Log(ctx, "ViewerUsecase.Lint").WithField("fileId", request.FileId)
+	defer stopwatch.StartWithLogger(log).Stop()
+
+	if getSession(ctx) == nil {
+		log.Error("get user session failed")
+		return nil, ErrUnauthorized
+	}
+
+	if err := uc.authorize(ctx, entities.RoleViewer); err != nil {
+		return nil, err
+	}
+
+	if err := uc.authorizeFile(ctx, request.FileId, claimNone); err != nil {
+		return nil, err
+	}
+
+	if err := uc.authorizeView(ctx, request.FileId); err != nil {
+		return nil, err
+	}
+
+	_, _, codelab, err := uc.parseCodeLabs(ctx, request.FileId)
+	if codelab == nil {
+		log.WithError(err).Error("parse codelab failed")
+		return nil, err
+	}
+
+	issues := uc.lintCodelab(codelab)
+	log.WithField("issues", len(issues)).Info("codelab linted")
+
+	return &requests.ViewerLintResponse{
+		Valid:  !hasLintErrors(issues),
+		Issues: issues,
+	}, nil
+}
+
+// lintCodelab checks the codelab against the enabled rules.
+func (uc *viewerUsecase) lintCodelab(codelab *types.Codelab) []*entities.LintIssue {
+	issues := make([]*entities.LintIssue, 0)
+	for _, rule := range lintRules {
+		severity := rule.Severity
+		if s, ok := uc.lintConfig.Rules[rule.Name]; ok {
+			severity = s
+		}
+
+		if severity != entities.LintSeverityError && severity != entities.LintSeverityWarning {
+			continue
+		}
+
+		for _, issue := range rule.Check(uc.lintConfig, codelab) {
+			issue.Rule = rule.Name
+			issue.Severity = severity
+			issues = append(issues, issue)
+		}
+	}
+
+	return issues
+}
+
+func hasLintErrors(issues []*entities.LintIssue) bool {
+	for _, issue := range issues {
+		if issue.Severity == entities.LintSeverityError {
+			return true
+		}
+	}
+
+	return false
+}
+
+func lintMetadata(config *entities.LintConfig, codelab *types.Codelab) []*entities.LintIssue {
+	var issues []*entities.LintIssue
+	for _, key := range config.Metadata {
+		if strings.TrimSpace(metadataValue(codelab, key)) == "" {
+			issues = append(issues, &entities.LintIssue{Message: fmt.Sprintf("missing %s metadata", key)})
+		}
+	}
+
+	return issues
+}
+
+// metadataValue returns the value of a metadata named after the draft metadata keys.
+func metadataValue(codelab *types.Codelab, key string) string {
+	switch strings.ToLower(key) {
+	case strings.ToLower(requests.ViewerDraftKeyTitle):
+		return codelab.Title
+	case strings.ToLower(requests.ViewerDraftKeySummary):
+		return codelab.Summary
+	case strings.ToLower(requests.ViewerDraftKeySlug), "id":
+		return codelab.ID
+	case strings.ToLower(requests.ViewerDraftKeyType), "categories":
+		return strings.Join(codelab.Categories, ",")
+	case strings.ToLower(requests.ViewerDraftKeyTags):
+		return strings.Join(codelab.Tags, ",")
+	case strings.ToLower(requests.ViewerDraftKeyStatus):
+		if codelab.Status == nil {
+			return ""
+		}
+		return strings.Join(*codelab.Status, ",")
+	case strings.ToLower(requests.ViewerDraftKeyFeedbackLink), "feedback":
+		return codelab.Feedback
+	case strings.ToLower(requests.ViewerDraftKeyAuthor), "authors":
+		return codelab.Authors
+	case strings.ToLower(requests.ViewerDraftKeyAnalyticsAccount), "ga":
+		return codelab.GA
+	}
+
+	return codelab.Extra[strings.ToLower(key)]
+}
+
+func lintFeedback(config *entities.LintConfig, codelab *types.Codelab) []*entities.LintIssue {
+	if strings.TrimSpace(codelab.Feedback) != "" {
+		return nil
+	}
+
+	return []*entities.LintIssue{{Message: "missing feedback link"}}
+}
+
+func lintSteps(config *entities.LintConfig, codelab *types.Codelab) []*entities.LintIssue {
+	if len(codelab.Steps) >= config.MinSteps {
+		return nil
+	}
+
+	return []*entities.LintIssue{{Message: fmt.Sprintf("%d steps, at least %d expected", len(codelab.Steps), config.MinSteps)}}
+}
+
+func lintEmptySteps(config *entities.LintConfig, codelab *types.Codelab) []*entities.LintIssue {
+	var issues []*entities.LintIssue
+	for i, step := range codelab.Steps {
+		if strings.TrimSpace(step.Title) == "" {
+			issues = append(issues, &entities.LintIssue{Step: i + 1, Message: "step has no title"})
+		}
+		if step.Content == nil || step.Content.Empty() {
+			issues = append(issues, &entities.LintIssue{Step: i + 1, Message: "step has no content"})
+		}
+	}
+
+	return issues
+}
+
+func lintDurations(config *entities.LintConfig, codelab *types.Codelab) []*entities.LintIssue {
+	var issues []*entities.LintIssue
+	for i, step := range codelab.Steps {
+		if step.Duration <= 0 {
+			issues = append(issues, &entities.LintIssue{Step: i + 1, Message: "step has no duration"})
+		}
+	}
+
+	return issues
+}
+
+func lintImageAlts(config *entities.LintConfig, codelab *types.Codelab) []*entities.LintIssue {
+	var issues []*entities.LintIssue
+	for i, step := range codelab.Steps {
+		if step.Content == nil {
+			continue
+		}
+
+		for _, n := range types.ImageNodes(step.Content.Nodes) {
+			if strings.TrimSpace(n.Alt) == "" {
+				issues = append(issues, &entities.LintIssue{Step: i + 1, Message: fmt.Sprintf("image %s has no alt text", n.Src)})
+			}
+		}
+	}
+
+	return issues
+}
+
+// lintHeadingLevels reports headers skipping a level, the first header of a step being at stepHeaderLevel at most.
+func lintHeadingLevels(config *entities.LintConfig, codelab *types.Codelab) []*entities.LintIssue {
+	var issues []*entities.LintIssue
+	for i, step := range codelab.Steps {
+		if step.Content == nil {
+			continue
+		}
+
+		level := stepHeaderLevel - 1
+		for _, n := range step.Content.Nodes {
+			h, ok := n.(*types.HeaderNode)
+			if !ok {
+				continue
+			}
+
+			if h.Level > level+1 {
+				issues = append(issues, &entities.LintIssue{Step: i + 1, Message: fmt.Sprintf("heading level %d follows level %d", h.Level, level)})
+			}
+			level = h.Level
+		}
+	}
+
+	return issues
+}
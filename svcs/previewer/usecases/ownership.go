@@ -0,0 +1,429 @@
+package usecases
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	cp "github.com/foxfoxio/codelabs-preview-go/internal"
+	"github.com/foxfoxio/codelabs-preview-go/internal/gdrive"
+	"github.com/foxfoxio/codelabs-preview-go/internal/gstorage"
+	"github.com/foxfoxio/codelabs-preview-go/internal/stopwatch"
+	"github.com/foxfoxio/codelabs-preview-go/internal/utils"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities/requests"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	ownershipFileName          = "ownership.json"
+	maxUpdateOwnershipAttempts = 10
+)
+
+var (
+	ErrInvalidEmail      = errors.New("invalid email")
+	ErrOwnershipConflict = errors.New("ownership conflict")
+)
+
+// fileClaim tells whether a new codelab may be claimed by the user of the session.
+type fileClaim int
+
+const (
+	// claimNone never claims
+	claimNone fileClaim = iota
+	// claimDrive claims drive documents the service account can read and no one else owns
+	claimDrive
+	// claimUpload claims the codelabs created by uploading their content
+	claimUpload
+)
+
+var emailPattern = regexp.MustCompile(`^[^@\s]+@[a-z0-9.-]+\.[a-z]+$`)
+
+func (uc *viewerUsecase) ownershipPath(fileId string) string {
+	return fmt.Sprintf("%s/%s/%s", uc.storagePath, fileId, ownershipFileName)
+}
+
+// readOwnership returns the ownership of the codelab and the generation of its file.
+func (uc *viewerUsecase) readOwnership(ctx context.Context, fileId string) (*entities.Ownership, int64, error) {
+	ownershipPath := uc.ownershipPath(fileId)
+
+	attrs, err := uc.gStorageClient.Stat(ctx, ownershipPath)
+	if err != nil {
+		if gstorage.IsNotExistError(err) {
+			return nil, 0, ErrNotFound
+		}
+		return nil, 0, err
+	}
+
+	ownershipBytes, err := uc.gStorageClient.Read(ctx, ownershipPath)
+	if err != nil {
+		if gstorage.IsNotExistError(err) {
+			return nil, 0, ErrNotFound
+		}
+		return nil, 0, err
+	}
+
+	ownership := &entities.Ownership{}
+	if err := json.Unmarshal(ownershipBytes.Bytes(), ownership); err != nil {
+		return nil, 0, err
+	}
+
+	return ownership, attrs.Generation, nil
+}
+
+// createOwnership records owner as the owner of the codelab, the ownership recorded first is returned
+// when the codelab already has an owner.
+func (uc *viewerUsecase) createOwnership(ctx context.Context, fileId string, owner string) (*entities.Ownership, error) {
+	log := cp.Log(ctx, "ViewerUsecase.createOwnership").WithField("fileId", fileId).WithField("owner", owner)
+
+	ownership := &entities.Ownership{
+		FileId:        fileId,
+		Owner:         strings.ToLower(owner),
+		CreatedDate:   time.Now(),
+		Collaborators: []*entities.Collaborator{},
+	}
+
+	ownershipPath := uc.ownershipPath(fileId)
+	size, err := uc.gStorageClient.WriteIfGenerationMatch(ctx, ownershipPath, bytes.NewBufferString(utils.StringifyIndent(ownership)), 0)
+
+	if gstorage.IsPreconditionFailedError(err) {
+		log.Warn("codelab already owned")
+		ownership, _, err = uc.readOwnership(ctx, fileId)
+		return ownership, err
+	}
+
+	if err != nil {
+		log.WithError(err).WithField("path", ownershipPath).Error("write ownership file failed")
+		return nil, err
+	}
+
+	log.WithField("size", size).WithField("path", ownershipPath).Info("ownership recorded")
+
+	return ownership, nil
+}
+
+// updateOwnership applies update to the ownership of the codelab, retrying when it changed in the meantime.
+func (uc *viewerUsecase) updateOwnership(ctx context.Context, fileId string, update func(ownership *entities.Ownership) error) (*entities.Ownership, error) {
+	log := cp.Log(ctx, "ViewerUsecase.updateOwnership").WithField("fileId", fileId)
+	ownershipPath := uc.ownershipPath(fileId)
+
+	for attempt := 0; attempt < maxUpdateOwnershipAttempts; attempt++ {
+		ownership, generation, err := uc.readOwnership(ctx, fileId)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := update(ownership); err != nil {
+			return nil, err
+		}
+
+		size, err := uc.gStorageClient.WriteIfGenerationMatch(ctx, ownershipPath, bytes.NewBufferString(utils.StringifyIndent(ownership)), generation)
+		if err == nil {
+			log.WithField("size", size).WithField("path", ownershipPath).Info("ownership updated")
+			return ownership, nil
+		}
+
+		if !gstorage.IsPreconditionFailedError(err) {
+			log.WithError(err).WithField("path", ownershipPath).Error("write ownership file failed")
+			return nil, err
+		}
+
+		log.Warn("ownership changed, retrying")
+	}
+
+	return nil, ErrOwnershipConflict
+}
+
+// authorizeFile checks that the user of the session owns or collaborates on the codelab, admins are always allowed.
+// Codelabs without a recorded owner are backfilled, see backfillOwnership, new codelabs are claimed by the user
+// as allowed by claim.
+func (uc *viewerUsecase) authorizeFile(ctx context.Context, fileId string, claim fileClaim) error {
+	log := cp.Log(ctx, "ViewerUsecase.authorizeFile").WithField("fileId", fileId)
+
+	session := getSession(ctx)
+	if session == nil {
+		log.Error("get user session failed")
+		return ErrUnauthorized
+	}
+
+	role, err := uc.sessionRole(ctx, session)
+	if err != nil {
+		log.WithError(err).Error("resolve role failed")
+		return err
+	}
+
+	if role == entities.RoleAdmin {
+		return nil
+	}
+
+	ownership, _, err := uc.readOwnership(ctx, fileId)
+	if err == ErrNotFound {
+		ownership, err = uc.backfillOwnership(ctx, fileId, session, claim)
+		if err == ErrNotFound {
+			// never published and without a drive owner, there is nothing to protect yet
+			return nil
+		}
+	}
+
+	if err != nil {
+		log.WithError(err).Error("read ownership failed")
+		return err
+	}
+
+	if !ownership.Allows(session.Email) {
+		log.WithField("email", session.Email).WithField("owner", ownership.Owner).Error("not an owner or collaborator")
+		return ErrForbidden
+	}
+
+	return nil
+}
+
+// backfillOwnership records the owner of a codelab without a recorded owner. Drive documents are owned by the owner
+// of the document, and codelabs published before owners were recorded with no drive owner by the admin. Codelabs
+// never published are claimed by the user of the session when the service account can read the document, or when
+// its content is uploaded. A document that cannot be read may belong to anyone, ErrForbidden is returned when it
+// is claimed and ErrNotFound otherwise.
+func (uc *viewerUsecase) backfillOwnership(ctx context.Context, fileId string, session *entities.UserSession, claim fileClaim) (*entities.Ownership, error) {
+	log := cp.Log(ctx, "ViewerUsecase.backfillOwnership").WithField("fileId", fileId)
+
+	owner := ""
+	readable := false
+	f, err := uc.driveClient.StatFile(ctx, fileId)
+	if err == nil {
+		readable = true
+		if len(f.Owners) > 0 {
+			owner = f.Owners[0]
+		}
+	} else if !gdrive.IsNotFoundError(err) {
+		log.WithError(err).Error("google drive, stat file failed")
+		return nil, err
+	}
+
+	if owner == "" {
+		_, err := uc.readMeta(ctx, uc.objectPath(fileId, 0, metaFileName))
+		switch {
+		case err == ErrNotFound && (claim == claimUpload || claim == claimDrive && readable):
+			owner = session.Email
+		case err == ErrNotFound && claim == claimDrive:
+			log.WithField("email", session.Email).Error("drive file not found, not claimed")
+			return nil, ErrForbidden
+		case err == ErrNotFound:
+			return nil, ErrNotFound
+		case err == nil:
+			owner = uc.adminEmail
+		default:
+			log.WithError(err).Error("read latest meta file failed")
+			return nil, err
+		}
+	}
+
+	if owner == "" {
+		log.Error("no owner to backfill")
+		return nil, ErrForbidden
+	}
+
+	log.WithField("owner", owner).Info("ownership backfilled")
+
+	return uc.createOwnership(ctx, fileId, owner)
+}
+
+// authorizeOwner checks that the user of the session is the owner of the codelab or an admin.
+func (uc *viewerUsecase) authorizeOwner(ctx context.Context, session *entities.UserSession, ownership *entities.Ownership) error {
+	if strings.EqualFold(ownership.Owner, session.Email) {
+		return nil
+	}
+
+	role, err := uc.sessionRole(ctx, session)
+	if err != nil {
+		return err
+	}
+
+	if role != entities.RoleAdmin {
+		return ErrForbidden
+	}
+
+	return nil
+}
+
+// driveFile reports whether the codelab is published from a drive document, markdown uploads have no drive file.
+func (uc *viewerUsecase) driveFile(ctx context.Context, fileId string) bool {
+	latestMeta, err := uc.readMeta(ctx, uc.objectPath(fileId, 0, metaFileName))
+	return err != nil || latestMeta.Source != entities.SourceMarkdown
+}
+
+func (uc *viewerUsecase) Collaborators(ctx context.Context, request *requests.ViewerCollaboratorsRequest) (*requests.ViewerCollaboratorsResponse, error) {
+	log := cp.Log(ctx, "ViewerUsecase.Collaborators").WithField("fileId", request.FileId)
+	defer stopwatch.StartWithLogger(log).Stop()
+
+	session := getSession(ctx)
+
+	if session == nil {
+		log.Errorf("get user session failed")
+		return nil, ErrUnauthorized
+	}
+
+	if err := uc.authorize(ctx, entities.RoleViewer); err != nil {
+		return nil, err
+	}
+
+	ownership, _, err := uc.readOwnership(ctx, request.FileId)
+	if err != nil {
+		log.WithError(err).Error("read ownership failed")
+		return nil, err
+	}
+
+	// emails of the owner and collaborators are only shown to them
+	if !ownership.Allows(session.Email) {
+		if err := uc.authorizeOwner(ctx, session, ownership); err != nil {
+			return nil, err
+		}
+	}
+
+	return &requests.ViewerCollaboratorsResponse{Ownership: ownership}, nil
+}
+
+// AddCollaborator lets email publish, promote and delete the codelab, and grants it write access to the drive document.
+// Only the owner and admins manage collaborators.
+func (uc *viewerUsecase) AddCollaborator(ctx context.Context, request *requests.ViewerAddCollaboratorRequest) (*requests.ViewerAddCollaboratorResponse, error) {
+	log := cp.Log(ctx, "ViewerUsecase.AddCollaborator").WithField("fileId", request.FileId).WithField("email", request.Email)
+	defer stopwatch.StartWithLogger(log).Stop()
+
+	session := getSession(ctx)
+
+	if session == nil {
+		log.Errorf("get user session failed")
+		return nil, ErrUnauthorized
+	}
+
+	if err := uc.authorize(ctx, entities.RoleAuthor); err != nil {
+		return nil, err
+	}
+
+	email := strings.ToLower(strings.TrimSpace(request.Email))
+	if !emailPattern.MatchString(email) {
+		return nil, ErrInvalidEmail
+	}
+
+	ownership, _, err := uc.readOwnership(ctx, request.FileId)
+	if err != nil {
+		log.WithError(err).Error("read ownership failed")
+		return nil, err
+	}
+
+	if err := uc.authorizeOwner(ctx, session, ownership); err != nil {
+		return nil, err
+	}
+
+	if ownership.Allows(email) {
+		return &requests.ViewerAddCollaboratorResponse{Ownership: ownership}, nil
+	}
+
+	collaborator := &entities.Collaborator{
+		Email:     email,
+		AddedBy:   session.Email,
+		AddedDate: time.Now(),
+	}
+
+	if uc.driveFile(ctx, request.FileId) {
+		s, err := uc.driveClient.GrantWritePermission(ctx, request.FileId, email)
+
+		if err != nil {
+			log.WithError(err).Error("google drive, share file failed")
+			return nil, err
+		}
+
+		log.WithField("permission_id", s.Id).Info("file shared")
+		collaborator.PermissionId = s.Id
+	}
+
+	ownership, err = uc.updateOwnership(ctx, request.FileId, func(ownership *entities.Ownership) error {
+		if !ownership.Allows(email) {
+			ownership.Collaborators = append(ownership.Collaborators, collaborator)
+		}
+		return nil
+	})
+
+	if err != nil {
+		log.WithError(err).Error("update ownership failed")
+
+		// the collaborator is not recorded, its drive access must not outlive the failure
+		if collaborator.PermissionId != "" {
+			if e := uc.driveClient.RevokePermission(ctx, request.FileId, collaborator.PermissionId); e != nil {
+				log.WithError(e).WithField("permission_id", collaborator.PermissionId).Error("google drive, unshare file failed")
+			}
+		}
+
+		return nil, err
+	}
+
+	return &requests.ViewerAddCollaboratorResponse{Ownership: ownership}, nil
+}
+
+// RemoveCollaborator revokes the access of email to the codelab and its drive document.
+// Collaborators may remove themselves, others are removed by the owner and admins.
+func (uc *viewerUsecase) RemoveCollaborator(ctx context.Context, request *requests.ViewerRemoveCollaboratorRequest) (*requests.ViewerRemoveCollaboratorResponse, error) {
+	log := cp.Log(ctx, "ViewerUsecase.RemoveCollaborator").WithField("fileId", request.FileId).WithField("email", request.Email)
+	defer stopwatch.StartWithLogger(log).Stop()
+
+	session := getSession(ctx)
+
+	if session == nil {
+		log.Errorf("get user session failed")
+		return nil, ErrUnauthorized
+	}
+
+	if err := uc.authorize(ctx, entities.RoleAuthor); err != nil {
+		return nil, err
+	}
+
+	ownership, _, err := uc.readOwnership(ctx, request.FileId)
+	if err != nil {
+		log.WithError(err).Error("read ownership failed")
+		return nil, err
+	}
+
+	if !strings.EqualFold(request.Email, session.Email) {
+		if err := uc.authorizeOwner(ctx, session, ownership); err != nil {
+			return nil, err
+		}
+	}
+
+	collaborator := ownership.Collaborator(request.Email)
+	if collaborator == nil {
+		return nil, ErrNotFound
+	}
+
+	if collaborator.PermissionId != "" {
+		err := uc.driveClient.RevokePermission(ctx, request.FileId, collaborator.PermissionId)
+
+		if err != nil && !gdrive.IsNotFoundError(err) {
+			log.WithError(err).Error("google drive, unshare file failed")
+			return nil, err
+		}
+
+		log.WithField("permission_id", collaborator.PermissionId).Info("file unshared")
+	}
+
+	ownership, err = uc.updateOwnership(ctx, request.FileId, func(ownership *entities.Ownership) error {
+		collaborators := ownership.Collaborators[:0]
+		for _, c := range ownership.Collaborators {
+			if !strings.EqualFold(c.Email, request.Email) {
+				collaborators = append(collaborators, c)
+			}
+		}
+		ownership.Collaborators = collaborators
+		return nil
+	})
+
+	if err != nil {
+		log.WithError(err).Error("update ownership failed")
+		return nil, err
+	}
+
+	log.WithField("by", session.Email).Info("collaborator removed")
+
+	return &requests.ViewerRemoveCollaboratorResponse{Ownership: ownership}, nil
+}
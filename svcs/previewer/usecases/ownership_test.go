@@ -0,0 +1,217 @@
+package usecases
+
+import (
+	"context"
+	"github.com/foxfoxio/codelabs-preview-go/internal/ctx_helper"
+	"github.com/foxfoxio/codelabs-preview-go/internal/gstorage"
+	"github.com/foxfoxio/codelabs-preview-go/internal/policy"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities/requests"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestViewerOwnership(t *testing.T) {
+	ctx := context.Background()
+	uc := newTestViewer(gstorage.NewMemoryClient())
+	uc.adminEmail = "admin@example.com"
+	uc.policies = policy.NewMemoryStore()
+	uc.defaultRole = entities.RoleReviewer
+	driveClient := uc.driveClient.(*fakeDriveClient)
+
+	admin := ctx_helper.AppendSession(ctx, &entities.UserSession{Email: "admin@example.com"})
+	owner := ctx_helper.AppendSession(ctx, &entities.UserSession{Email: "owner@example.com"})
+	other := ctx_helper.AppendSession(ctx, &entities.UserSession{Email: "other@example.com"})
+
+	_, err := uc.Collaborators(owner, &requests.ViewerCollaboratorsRequest{FileId: "doc"})
+	assert.Equal(t, ErrNotFound, err)
+
+	// the first publish claims codelabs without a recorded owner
+	_, err = uc.Publish(owner, &requests.ViewerPublishRequest{FileId: "doc"})
+	assert.NoError(t, err)
+
+	_, err = uc.Publish(other, &requests.ViewerPublishRequest{FileId: "doc"})
+	assert.Equal(t, ErrForbidden, err)
+
+	_, err = uc.Promote(other, &requests.ViewerPromoteRequest{FileId: "doc", Revision: 1})
+	assert.Equal(t, ErrForbidden, err)
+
+	_, err = uc.AddCollaborator(other, &requests.ViewerAddCollaboratorRequest{FileId: "doc", Email: "other@example.com"})
+	assert.Equal(t, ErrForbidden, err)
+
+	_, err = uc.AddCollaborator(owner, &requests.ViewerAddCollaboratorRequest{FileId: "doc", Email: "not an email"})
+	assert.Equal(t, ErrInvalidEmail, err)
+
+	res, err := uc.AddCollaborator(owner, &requests.ViewerAddCollaboratorRequest{FileId: "doc", Email: "Other@Example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, "owner@example.com", res.Ownership.Owner)
+	if assert.Len(t, res.Ownership.Collaborators, 1) {
+		assert.Equal(t, "other@example.com", res.Ownership.Collaborators[0].Email)
+		assert.Equal(t, "owner@example.com", res.Ownership.Collaborators[0].AddedBy)
+		assert.Equal(t, "doc/other@example.com", driveClient.permissions[res.Ownership.Collaborators[0].PermissionId])
+	}
+
+	_, err = uc.AddCollaborator(owner, &requests.ViewerAddCollaboratorRequest{FileId: "doc", Email: "other@example.com"})
+	assert.NoError(t, err)
+	assert.Len(t, driveClient.permissions, 1, "existing collaborators are not shared again")
+
+	_, err = uc.Publish(other, &requests.ViewerPublishRequest{FileId: "doc"})
+	assert.NoError(t, err)
+
+	_, err = uc.Promote(other, &requests.ViewerPromoteRequest{FileId: "doc", Revision: 1})
+	assert.NoError(t, err)
+
+	_, err = uc.Publish(admin, &requests.ViewerPublishRequest{FileId: "doc"})
+	assert.NoError(t, err)
+
+	// removing collaborators needs the same role as adding them
+	assert.NoError(t, uc.policies.Grant(ctx, "other@example.com", entities.RoleViewer))
+	_, err = uc.RemoveCollaborator(other, &requests.ViewerRemoveCollaboratorRequest{FileId: "doc", Email: "other@example.com"})
+	assert.Equal(t, ErrForbidden, err)
+	assert.NoError(t, uc.policies.Revoke(ctx, "other@example.com"))
+
+	removeRes, err := uc.RemoveCollaborator(admin, &requests.ViewerRemoveCollaboratorRequest{FileId: "doc", Email: "other@example.com"})
+	assert.NoError(t, err)
+	assert.Empty(t, removeRes.Ownership.Collaborators)
+	assert.Empty(t, driveClient.permissions)
+
+	_, err = uc.RemoveCollaborator(owner, &requests.ViewerRemoveCollaboratorRequest{FileId: "doc", Email: "other@example.com"})
+	assert.Equal(t, ErrNotFound, err)
+
+	_, err = uc.Delete(other, &requests.ViewerDeleteRequest{FileId: "doc"})
+	assert.Equal(t, ErrForbidden, err)
+
+	_, err = uc.Delete(owner, &requests.ViewerDeleteRequest{FileId: "doc"})
+	assert.NoError(t, err)
+}
+
+func TestViewerOwnershipBackfill(t *testing.T) {
+	ctx := context.Background()
+	storage := gstorage.NewMemoryClient()
+	uc := newTestViewer(storage)
+	uc.adminEmail = "admin@example.com"
+	driveClient := uc.driveClient.(*fakeDriveClient)
+	driveClient.owners = map[string]string{"doc": "Drive.Owner@example.com"}
+	driveClient.docs["legacy"] = testCodelabDoc
+
+	admin := ctx_helper.AppendSession(ctx, &entities.UserSession{Email: "admin@example.com"})
+	owner := ctx_helper.AppendSession(ctx, &entities.UserSession{Email: "drive.owner@example.com"})
+	other := ctx_helper.AppendSession(ctx, &entities.UserSession{Email: "other@example.com"})
+
+	// drive documents are owned by their drive owner, not by their first publisher
+	_, err := uc.Publish(other, &requests.ViewerPublishRequest{FileId: "doc"})
+	assert.Equal(t, ErrForbidden, err)
+
+	_, err = uc.Publish(owner, &requests.ViewerPublishRequest{FileId: "doc"})
+	assert.NoError(t, err)
+
+	res, err := uc.Collaborators(owner, &requests.ViewerCollaboratorsRequest{FileId: "doc"})
+	assert.NoError(t, err)
+	assert.Equal(t, "drive.owner@example.com", res.Ownership.Owner)
+
+	// codelabs published before owners were recorded, without a drive owner, are left to the admin
+	_, err = uc.Publish(admin, &requests.ViewerPublishRequest{FileId: "legacy"})
+	assert.NoError(t, err)
+	_, _, err = uc.readOwnership(ctx, "legacy")
+	assert.Equal(t, ErrNotFound, err)
+
+	for _, user := range []context.Context{other, owner} {
+		_, err = uc.Promote(user, &requests.ViewerPromoteRequest{FileId: "legacy", Revision: 1})
+		assert.Equal(t, ErrForbidden, err)
+
+		_, err = uc.Publish(user, &requests.ViewerPublishRequest{FileId: "legacy"})
+		assert.Equal(t, ErrForbidden, err)
+	}
+
+	_, err = uc.Promote(admin, &requests.ViewerPromoteRequest{FileId: "legacy", Revision: 1})
+	assert.NoError(t, err)
+
+	res, err = uc.Collaborators(admin, &requests.ViewerCollaboratorsRequest{FileId: "legacy"})
+	assert.NoError(t, err)
+	assert.Equal(t, "admin@example.com", res.Ownership.Owner)
+}
+
+func TestViewerOwnershipUnreadable(t *testing.T) {
+	ctx := context.Background()
+	uc := newTestViewer(gstorage.NewMemoryClient())
+	driveClient := uc.driveClient.(*fakeDriveClient)
+
+	owner := ctx_helper.AppendSession(ctx, &entities.UserSession{Email: "owner@example.com"})
+	other := ctx_helper.AppendSession(ctx, &entities.UserSession{Email: "other@example.com"})
+
+	// documents the service account cannot read yet may belong to anyone, they are not claimed
+	_, err := uc.Publish(other, &requests.ViewerPublishRequest{FileId: "private"})
+	assert.Equal(t, ErrForbidden, err)
+	_, _, err = uc.readOwnership(ctx, "private")
+	assert.Equal(t, ErrNotFound, err)
+
+	// once shared, the document is owned by its drive owner
+	driveClient.docs["private"] = testCodelabDoc
+	driveClient.owners = map[string]string{"private": "owner@example.com"}
+
+	_, err = uc.Publish(other, &requests.ViewerPublishRequest{FileId: "private"})
+	assert.Equal(t, ErrForbidden, err)
+	_, err = uc.Publish(owner, &requests.ViewerPublishRequest{FileId: "private"})
+	assert.NoError(t, err)
+
+	// uploads create their content and are claimed by the uploader
+	_, err = uc.Publish(other, &requests.ViewerPublishRequest{FileId: "upload", Markdown: []byte(testCodelabMarkdown)})
+	assert.NoError(t, err)
+
+	ownership, _, err := uc.readOwnership(ctx, "upload")
+	assert.NoError(t, err)
+	assert.Equal(t, "other@example.com", ownership.Owner)
+}
+
+func TestViewerOwnershipWithoutPolicies(t *testing.T) {
+	ctx := context.Background()
+	uc := newTestViewer(gstorage.NewMemoryClient())
+
+	owner := ctx_helper.AppendSession(ctx, &entities.UserSession{Email: "owner@example.com"})
+	other := ctx_helper.AppendSession(ctx, &entities.UserSession{Email: "other@example.com"})
+
+	_, err := uc.Publish(owner, &requests.ViewerPublishRequest{FileId: "doc"})
+	assert.NoError(t, err)
+
+	// ownership is enforced whether roles are configured or not
+	_, err = uc.Publish(other, &requests.ViewerPublishRequest{FileId: "doc"})
+	assert.Equal(t, ErrForbidden, err)
+
+	_, err = uc.Delete(other, &requests.ViewerDeleteRequest{FileId: "doc", Revision: 1})
+	assert.Equal(t, ErrForbidden, err)
+
+	_, err = uc.AddCollaborator(other, &requests.ViewerAddCollaboratorRequest{FileId: "doc", Email: "other@example.com"})
+	assert.Equal(t, ErrForbidden, err)
+
+	// owner and collaborator emails are only shown to them
+	_, err = uc.Collaborators(other, &requests.ViewerCollaboratorsRequest{FileId: "doc"})
+	assert.Equal(t, ErrForbidden, err)
+
+	_, err = uc.AddCollaborator(owner, &requests.ViewerAddCollaboratorRequest{FileId: "doc", Email: "other@example.com"})
+	assert.NoError(t, err)
+
+	res, err := uc.Collaborators(other, &requests.ViewerCollaboratorsRequest{FileId: "doc"})
+	assert.NoError(t, err)
+	assert.Equal(t, "owner@example.com", res.Ownership.Owner)
+}
+
+func TestViewerAddCollaboratorRollback(t *testing.T) {
+	owner := ctx_helper.AppendSession(context.Background(), &entities.UserSession{Email: "owner@example.com"})
+	storage := gstorage.NewMemoryClient()
+	uc := newTestViewer(storage)
+	driveClient := uc.driveClient.(*fakeDriveClient)
+
+	_, err := uc.Publish(owner, &requests.ViewerPublishRequest{FileId: "doc"})
+	assert.NoError(t, err)
+
+	uc.gStorageClient = &failingStorage{Client: storage, suffix: ownershipFileName}
+	_, err = uc.AddCollaborator(owner, &requests.ViewerAddCollaboratorRequest{FileId: "doc", Email: "other@example.com"})
+	assert.Error(t, err)
+	assert.Empty(t, driveClient.permissions, "the drive access of an unrecorded collaborator is revoked")
+
+	ownership, _, err := uc.readOwnership(owner, "doc")
+	assert.NoError(t, err)
+	assert.Empty(t, ownership.Collaborators)
+	assert.False(t, strings.Contains(ownership.Owner, "other"))
+}
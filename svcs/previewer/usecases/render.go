@@ -0,0 +1,104 @@
+package usecases
+
+import (
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities"
+	"github.com/googlecodelabs/tools/claat/types"
+	"net/url"
+	"strings"
+)
+
+// Codelab metadata overriding the render context of the service.
+const (
+	renderPrefixMetadata = "prefix"
+	renderEnvMetadata    = "env"
+	themeMetadata        = "theme"
+)
+
+const (
+	defaultRenderPrefix = "https://storage.googleapis.com"
+	defaultRenderEnv    = "web"
+)
+
+// newRenderContext fills the values missing from rc with the defaults.
+func newRenderContext(rc *entities.RenderContext) *entities.RenderContext {
+	c := &entities.RenderContext{Prefix: defaultRenderPrefix, Env: defaultRenderEnv, Extra: map[string]string{}}
+	if rc == nil {
+		return c
+	}
+
+	if rc.Prefix != "" {
+		c.Prefix = strings.TrimSuffix(rc.Prefix, "/")
+	}
+
+	if rc.Env != "" {
+		c.Env = rc.Env
+	}
+
+	c.Theme = rc.Theme
+	c.Origins = rc.Origins
+
+	for k, v := range rc.Extra {
+		c.Extra[k] = v
+	}
+
+	return c
+}
+
+// passMetadata are the codelab metadata kept by the parser, the render overrides and the extra keys of the service.
+func (uc *viewerUsecase) passMetadata() map[string]bool {
+	pm := map[string]bool{renderPrefixMetadata: true, renderEnvMetadata: true, themeMetadata: true}
+	for k := range uc.renderContext.Extra {
+		pm[k] = true
+	}
+
+	return pm
+}
+
+// codelabRenderContext returns the render context of the service overridden by the metadata of the codelab.
+func (uc *viewerUsecase) codelabRenderContext(codelab *types.Codelab) *entities.RenderContext {
+	rc := newRenderContext(uc.renderContext)
+
+	for k, v := range codelab.Extra {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		switch k {
+		case renderPrefixMetadata:
+			// the prefix ends up in the rendered pages, only allowed origins are kept
+			if allowedPrefix(rc, v) {
+				rc.Prefix = strings.TrimSuffix(v, "/")
+			}
+		case renderEnvMetadata:
+			rc.Env = v
+		case themeMetadata:
+			rc.Theme = v
+		default:
+			if _, ok := rc.Extra[k]; ok {
+				rc.Extra[k] = v
+			}
+		}
+	}
+
+	return rc
+}
+
+// allowedPrefix reports whether prefix is an https url on the origin of the service prefix or on one of the
+// allowed origins of rc.
+func allowedPrefix(rc *entities.RenderContext, prefix string) bool {
+	u, err := url.Parse(prefix)
+	if err != nil || u.Scheme != "https" || u.Host == "" || u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return false
+	}
+
+	origin := strings.ToLower(u.Scheme + "://" + u.Host)
+	for _, allowed := range append([]string{rc.Prefix}, rc.Origins...) {
+		a, err := url.Parse(allowed)
+		if err == nil && a.Scheme == "https" && strings.ToLower(a.Scheme+"://"+a.Host) == origin {
+			return true
+		}
+	}
+
+	return false
+}
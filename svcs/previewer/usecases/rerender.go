@@ -0,0 +1,196 @@
+package usecases
+
+import (
+	"bytes"
+	"context"
+	cp "github.com/foxfoxio/codelabs-preview-go/internal"
+	"github.com/foxfoxio/codelabs-preview-go/internal/stopwatch"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities/requests"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultRerenderConcurrency = 4
+	maxRerenderConcurrency     = 16
+)
+
+// Rerender regenerates the index of every published revision with the current template.
+// Revisions are rendered from their stored codelab model, those published before models were stored are skipped
+// since the drive document may have changed since.
+func (uc *viewerUsecase) Rerender(ctx context.Context, request *requests.ViewerRerenderRequest) (*requests.ViewerRerenderResponse, error) {
+	log := cp.Log(ctx, "ViewerUsecase.Rerender").WithField("fileId", request.FileId).WithField("dryRun", request.DryRun)
+	defer stopwatch.StartWithLogger(log).Stop()
+
+	session := getSession(ctx)
+
+	if session == nil {
+		log.Errorf("get user session failed")
+		return nil, ErrUnauthorized
+	}
+
+	if err := uc.authorize(ctx, entities.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	files, err := uc.listPublishedFiles(ctx, request.FileId)
+	if err != nil {
+		log.WithError(err).Error("list published files failed")
+		return nil, err
+	}
+
+	concurrency := request.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultRerenderConcurrency
+	} else if concurrency > maxRerenderConcurrency {
+		concurrency = maxRerenderConcurrency
+	}
+
+	fileIds := make([]string, 0, len(files))
+	total := 0
+	for fileId, revisions := range files {
+		fileIds = append(fileIds, fileId)
+		total += len(revisions)
+	}
+	sort.Strings(fileIds)
+
+	log.WithField("files", len(fileIds)).WithField("revisions", total).Info("rerender started")
+
+	response := &requests.ViewerRerenderResponse{
+		Total:   total,
+		Results: make([]*entities.RerenderResult, 0, total),
+	}
+
+	// files are processed concurrently, the revisions of a file one after another
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, concurrency)
+
+	for _, fileId := range fileIds {
+		wg.Add(1)
+		semaphore <- struct{}{}
+
+		go func(fileId string, revisions []int) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			for _, revision := range revisions {
+				result := uc.rerenderRevision(ctx, fileId, revision, request.DryRun)
+
+				mu.Lock()
+				response.Results = append(response.Results, result)
+				switch {
+				case result.Error != "":
+					response.Failed++
+				case result.Skipped:
+					response.Skipped++
+				default:
+					response.Rendered++
+				}
+				log.WithField("done", len(response.Results)).WithField("total", total).
+					WithField("fileId", fileId).WithField("revision", revision).
+					Info("rerender progress")
+				mu.Unlock()
+			}
+		}(fileId, files[fileId])
+	}
+
+	wg.Wait()
+
+	sort.Slice(response.Results, func(i, j int) bool {
+		a, b := response.Results[i], response.Results[j]
+		if a.FileId != b.FileId {
+			return a.FileId < b.FileId
+		}
+		return a.Revision > b.Revision
+	})
+
+	log.WithField("rendered", response.Rendered).WithField("skipped", response.Skipped).WithField("failed", response.Failed).
+		Info("rerender finished")
+
+	return response, nil
+}
+
+// rerenderRevision renders a single revision from its stored model.
+func (uc *viewerUsecase) rerenderRevision(ctx context.Context, fileId string, revision int, dryRun bool) *entities.RerenderResult {
+	log := cp.Log(ctx, "ViewerUsecase.rerenderRevision").WithField("fileId", fileId).WithField("revision", revision)
+	result := &entities.RerenderResult{FileId: fileId, Revision: revision}
+
+	codelab, err := uc.readCodelab(ctx, fileId, revision)
+	if err == ErrNotFound {
+		log.Warn("no stored codelab, skipped")
+		result.Skipped = true
+		result.Reason = entities.RerenderNoModel
+		return result
+	}
+
+	if err != nil {
+		log.WithError(err).Error("read codelab failed")
+		result.Error = err.Error()
+		return result
+	}
+
+	var buffer bytes.Buffer
+	if err := uc.renderOutput(ctx, &buffer, codelab); err != nil {
+		log.WithError(err).Error("render codelab failed")
+		result.Error = err.Error()
+		return result
+	}
+	result.Size = buffer.Len()
+
+	if dryRun {
+		return result
+	}
+
+	if _, err := uc.gStorageClient.Write(ctx, uc.objectPath(fileId, revision, indexFileName), &buffer); err != nil {
+		log.WithError(err).Error("write index file failed")
+		result.Error = err.Error()
+		return result
+	}
+
+	// other formats are rendered again with the new template when requested
+	if err := uc.deleteFormats(ctx, fileId, revision); err != nil {
+		log.WithError(err).Error("delete formats failed")
+		result.Error = err.Error()
+		return result
+	}
+
+	return result
+}
+
+// listPublishedFiles returns the published revisions of every file, or only of fileId when given, newest first.
+func (uc *viewerUsecase) listPublishedFiles(ctx context.Context, fileId string) (map[string][]int, error) {
+	prefix := uc.storagePath + "/"
+	if fileId != "" {
+		prefix += fileId + "/"
+	}
+
+	objects, err := uc.gStorageClient.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string][]int)
+	for _, o := range objects {
+		parts := strings.Split(strings.TrimPrefix(o.Name, uc.storagePath+"/"), "/")
+		if len(parts) != 3 || parts[2] != indexFileName {
+			continue
+		}
+
+		revision, err := strconv.Atoi(parts[1])
+		if err != nil || revision <= 0 {
+			continue
+		}
+
+		files[parts[0]] = append(files[parts[0]], revision)
+	}
+
+	for _, revisions := range files {
+		sort.Sort(sort.Reverse(sort.IntSlice(revisions)))
+	}
+
+	return files, nil
+}
@@ -0,0 +1,101 @@
+package usecases
+
+import (
+	"context"
+	"github.com/foxfoxio/codelabs-preview-go/internal/ctx_helper"
+	"github.com/foxfoxio/codelabs-preview-go/internal/gstorage"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities/requests"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestViewerViewRerender(t *testing.T) {
+	ctx := authorContext()
+	storage := gstorage.NewMemoryClient()
+	uc := newTestViewer(storage)
+
+	_, err := uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "doc"})
+	assert.NoError(t, err)
+
+	// replace the stored index to tell both apart
+	_, err = storage.Write(ctx, uc.objectPath("doc", 1, indexFileName), strings.NewReader("stale"))
+	assert.NoError(t, err)
+
+	res, err := uc.View(ctx, &requests.ViewerViewRequest{FileId: "doc", Rerender: true})
+	assert.NoError(t, err)
+	assert.Contains(t, res.Response, "hello world")
+
+	res, err = uc.View(ctx, &requests.ViewerViewRequest{FileId: "doc"})
+	assert.NoError(t, err)
+	assert.Equal(t, "stale", res.Response)
+
+	// revisions without a stored codelab fall back to the stored index
+	assert.NoError(t, storage.Delete(ctx, uc.objectPath("doc", 1, codelabFileName)))
+	res, err = uc.View(ctx, &requests.ViewerViewRequest{FileId: "doc", Rerender: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "stale", res.Response)
+}
+
+func TestViewerRerender(t *testing.T) {
+	admin := ctx_helper.AppendSession(context.Background(), &entities.UserSession{Email: "admin@example.com"})
+	storage := gstorage.NewMemoryClient()
+	uc := newTestViewer(storage)
+	uc.adminEmail = "admin@example.com"
+
+	for i := 0; i < 3; i++ {
+		_, err := uc.Publish(admin, &requests.ViewerPublishRequest{FileId: "doc"})
+		assert.NoError(t, err)
+	}
+
+	// revisions 1 and 3 were published before codelab models were stored
+	for _, revision := range []int{1, 3} {
+		assert.NoError(t, storage.Delete(admin, uc.objectPath("doc", revision, codelabFileName)))
+	}
+	for _, revision := range []int{1, 2, 3} {
+		_, err := storage.Write(admin, uc.objectPath("doc", revision, indexFileName), strings.NewReader("stale"))
+		assert.NoError(t, err)
+	}
+
+	user := ctx_helper.AppendSession(context.Background(), &entities.UserSession{Email: "author@example.com"})
+	_, err := uc.Rerender(user, &requests.ViewerRerenderRequest{})
+	assert.Equal(t, ErrForbidden, err)
+
+	res, err := uc.Rerender(admin, &requests.ViewerRerenderRequest{DryRun: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, res.Total)
+	assert.Equal(t, 1, res.Rendered)
+	assert.Equal(t, 2, res.Skipped)
+
+	index, err := storage.Read(admin, uc.objectPath("doc", 2, indexFileName))
+	assert.NoError(t, err)
+	assert.Equal(t, "stale", index.String(), "dry run must not write")
+
+	res, err = uc.Rerender(admin, &requests.ViewerRerenderRequest{Concurrency: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, res.Failed)
+	if assert.Len(t, res.Results, 3) {
+		assert.Equal(t, 3, res.Results[0].Revision)
+		assert.True(t, res.Results[0].Skipped)
+		assert.Equal(t, entities.RerenderNoModel, res.Results[0].Reason)
+		assert.Equal(t, 2, res.Results[1].Revision)
+		assert.False(t, res.Results[1].Skipped)
+		assert.Equal(t, 1, res.Results[2].Revision)
+		assert.True(t, res.Results[2].Skipped)
+	}
+
+	index, err = storage.Read(admin, uc.objectPath("doc", 2, indexFileName))
+	assert.NoError(t, err)
+	assert.Contains(t, index.String(), "hello world")
+
+	// revisions without a model, latest included, keep their content rather than take the current document
+	for _, revision := range []int{1, 3} {
+		index, err := storage.Read(admin, uc.objectPath("doc", revision, indexFileName))
+		assert.NoError(t, err)
+		assert.Equal(t, "stale", index.String())
+
+		_, err = uc.readCodelab(admin, "doc", revision)
+		assert.Equal(t, ErrNotFound, err)
+	}
+}
@@ -0,0 +1,66 @@
+package usecases
+
+import (
+	"context"
+	cp "github.com/foxfoxio/codelabs-preview-go/internal"
+	codelabEncoding "github.com/foxfoxio/codelabs-preview-go/internal/codelab"
+	"github.com/foxfoxio/codelabs-preview-go/internal/stopwatch"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities/requests"
+	"time"
+)
+
+// Steps returns the structured steps of a revision, or only the requested step.
+// Revisions published before codelab models were stored have no steps.
+func (uc *viewerUsecase) Steps(ctx context.Context, request *requests.ViewerStepsRequest) (*requests.ViewerStepsResponse, error) {
+	log := cp.Log(ctx, "ViewerUsecase.Steps").WithField("fileId", request.FileId).WithField("revision", request.Revision).WithField("step", request.Step)
+	defer stopwatch.StartWithLogger(log).Stop()
+
+	if err := uc.authorizeView(ctx, request.FileId); err != nil {
+		return nil, err
+	}
+
+	revision, err := uc.resolveRevision(ctx, request.FileId, request.Revision)
+	if err != nil {
+		log.WithError(err).Error("resolve revision failed")
+		return nil, err
+	}
+
+	codelab, err := uc.readCodelab(ctx, request.FileId, revision)
+	if err != nil {
+		log.WithError(err).Error("read codelab failed")
+		return nil, err
+	}
+
+	first, last := 1, len(codelab.Steps)
+	if request.Step != 0 {
+		if request.Step < 1 || request.Step > len(codelab.Steps) {
+			return nil, ErrNotFound
+		}
+		first, last = request.Step, request.Step
+	}
+
+	steps := make([]*entities.Step, 0, last-first+1)
+	for i := first; i <= last; i++ {
+		step := codelab.Steps[i-1]
+		content, err := codelabEncoding.EncodeContent(step.Content)
+		if err != nil {
+			log.WithError(err).WithField("step", i).Error("encode step failed")
+			return nil, err
+		}
+
+		steps = append(steps, &entities.Step{
+			Index:    i,
+			Title:    step.Title,
+			Duration: int(step.Duration / time.Minute),
+			Tags:     step.Tags,
+			Content:  content,
+		})
+	}
+
+	return &requests.ViewerStepsResponse{
+		Revision: revision,
+		Total:    len(codelab.Steps),
+		Steps:    steps,
+	}, nil
+}
@@ -0,0 +1,72 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"github.com/foxfoxio/codelabs-preview-go/internal/theme"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities"
+	"github.com/googlecodelabs/tools/claat/render"
+	htmlTemplate "html/template"
+	"io"
+	"strings"
+)
+
+var ErrUnknownTheme = errors.New("unknown theme")
+
+// the codelab element is cut from the page of the claat html template, themes lay out the page around it
+const (
+	codelabElementStart = "<google-codelab-analytics"
+	codelabElementEnd   = "</google-codelab>"
+)
+
+var errCodelabElementNotFound = errors.New("codelab element not found in the claat html template")
+
+// themeData is what theme templates are executed with: the render context, the theme name and the rendered codelab element.
+type themeData struct {
+	render.Context
+	Theme   string
+	Codelab htmlTemplate.HTML
+}
+
+// renderTheme renders the codelab element and lays it out with the theme template.
+func (uc *viewerUsecase) renderTheme(ctx context.Context, w io.Writer, name string, rc render.Context) error {
+	if uc.themes == nil {
+		return ErrUnknownTheme
+	}
+
+	tmpl, err := uc.themes.Load(ctx, name)
+	if err == theme.ErrNotFound {
+		return ErrUnknownTheme
+	} else if err != nil {
+		return err
+	}
+
+	codelab, err := renderCodelabElement(rc)
+	if err != nil {
+		return err
+	}
+
+	return tmpl.Execute(w, &themeData{
+		Context: rc,
+		Theme:   name,
+		Codelab: htmlTemplate.HTML(codelab),
+	})
+}
+
+// renderCodelabElement renders the page of the claat html template and returns its codelab element,
+// so that themes follow the markup of the claat fork.
+func renderCodelabElement(rc render.Context) (string, error) {
+	var page strings.Builder
+	if err := render.Execute(&page, outputFormats[entities.FormatHTML].Template, &struct{ render.Context }{rc}); err != nil {
+		return "", err
+	}
+
+	html := page.String()
+	start := strings.Index(html, codelabElementStart)
+	end := strings.LastIndex(html, codelabElementEnd)
+	if start < 0 || end < start {
+		return "", errCodelabElementNotFound
+	}
+
+	return html[start : end+len(codelabElementEnd)], nil
+}
@@ -7,31 +7,90 @@ import (
 	"errors"
 	"fmt"
 	cp "github.com/foxfoxio/codelabs-preview-go/internal"
+	codelabEncoding "github.com/foxfoxio/codelabs-preview-go/internal/codelab"
 	"github.com/foxfoxio/codelabs-preview-go/internal/gdoc"
 	"github.com/foxfoxio/codelabs-preview-go/internal/gdrive"
 	"github.com/foxfoxio/codelabs-preview-go/internal/gstorage"
+	"github.com/foxfoxio/codelabs-preview-go/internal/linkcheck"
+	"github.com/foxfoxio/codelabs-preview-go/internal/netguard"
+	"github.com/foxfoxio/codelabs-preview-go/internal/policy"
 	"github.com/foxfoxio/codelabs-preview-go/internal/stopwatch"
+	"github.com/foxfoxio/codelabs-preview-go/internal/theme"
 	"github.com/foxfoxio/codelabs-preview-go/internal/utils"
 	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities"
 	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities/requests"
 	"github.com/googlecodelabs/tools/claat/fetch"
 	"github.com/googlecodelabs/tools/claat/parser"
 	_ "github.com/googlecodelabs/tools/claat/parser/gdoc"
-	"github.com/googlecodelabs/tools/claat/render"
+	_ "github.com/googlecodelabs/tools/claat/parser/md"
 	"github.com/googlecodelabs/tools/claat/types"
 	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
+const (
+	indexFileName   = "index.html"
+	metaFileName    = "meta.json"
+	codelabFileName = "codelab.json"
+)
+
+const (
+	maxClaimRevisionAttempts = 10
+	maxFlipLatestAttempts    = 10
+	defaultRevisionsLimit    = 20
+	maxRevisionsLimit        = 100
+	httpClientTimeout        = 30 * time.Second
+)
+
+var (
+	ErrNotFound         = errors.New("not found")
+	ErrUnauthorized     = errors.New("unauthorized")
+	ErrForbidden        = errors.New("forbidden")
+	ErrRevisionConflict = errors.New("revision conflict")
+	ErrUnsupportedFile  = errors.New("unsupported file type")
+	ErrLintFailed       = errors.New("lint failed")
+	ErrBrokenLinks      = errors.New("broken links")
+)
+
 type Viewer interface {
 	Parse(ctx context.Context, request *requests.ViewerParseRequest) (*requests.ViewerParseResponse, error)
 	Draft(ctx context.Context, request *requests.ViewerDraftRequest) (*requests.ViewerDraftResponse, error)
 	Publish(ctx context.Context, request *requests.ViewerPublishRequest) (*requests.ViewerPublishResponse, error)
 	View(ctx context.Context, request *requests.ViewerViewRequest) (*requests.ViewerViewResponse, error)
 	Meta(ctx context.Context, request *requests.ViewerMetaRequest) (*requests.ViewerMetaResponse, error)
+	Revisions(ctx context.Context, request *requests.ViewerRevisionsRequest) (*requests.ViewerRevisionsResponse, error)
+	Promote(ctx context.Context, request *requests.ViewerPromoteRequest) (*requests.ViewerPromoteResponse, error)
+	Delete(ctx context.Context, request *requests.ViewerDeleteRequest) (*requests.ViewerDeleteResponse, error)
+	Diff(ctx context.Context, request *requests.ViewerDiffRequest) (*requests.ViewerDiffResponse, error)
+	Rerender(ctx context.Context, request *requests.ViewerRerenderRequest) (*requests.ViewerRerenderResponse, error)
+	Image(ctx context.Context, request *requests.ViewerImageRequest) (*requests.ViewerImageResponse, error)
+	Bundle(ctx context.Context, request *requests.ViewerBundleRequest) (*requests.ViewerBundleResponse, error)
+	Lint(ctx context.Context, request *requests.ViewerLintRequest) (*requests.ViewerLintResponse, error)
+	Links(ctx context.Context, request *requests.ViewerLinksRequest) (*requests.ViewerLinksResponse, error)
+	Steps(ctx context.Context, request *requests.ViewerStepsRequest) (*requests.ViewerStepsResponse, error)
+	Roles(ctx context.Context, request *requests.ViewerRolesRequest) (*requests.ViewerRolesResponse, error)
+	GrantRole(ctx context.Context, request *requests.ViewerGrantRoleRequest) (*requests.ViewerGrantRoleResponse, error)
+	RevokeRole(ctx context.Context, request *requests.ViewerRevokeRoleRequest) (*requests.ViewerRevokeRoleResponse, error)
+	Collaborators(ctx context.Context, request *requests.ViewerCollaboratorsRequest) (*requests.ViewerCollaboratorsResponse, error)
+	AddCollaborator(ctx context.Context, request *requests.ViewerAddCollaboratorRequest) (*requests.ViewerAddCollaboratorResponse, error)
+	RemoveCollaborator(ctx context.Context, request *requests.ViewerRemoveCollaboratorRequest) (*requests.ViewerRemoveCollaboratorResponse, error)
+	SetVisibility(ctx context.Context, request *requests.ViewerSetVisibilityRequest) (*requests.ViewerSetVisibilityResponse, error)
 }
 
-func NewViewer(driveClient gdrive.Client, gDocClient gdoc.Client, gStorageClient gstorage.Client, templateFileId string, driveRootId string, adminEmail string, storagePath string) Viewer {
+// NewViewer creates the viewer usecase, codelabs are rendered with renderContext unless their metadata override it.
+// Themes are loaded from themes, which may be nil when no theme is available.
+// Codelabs are linted with lintConfig before being published, the default rules apply when nil.
+// Roles are granted by policies, users without a grant having defaultRole, access control is disabled when nil.
+func NewViewer(driveClient gdrive.Client, gDocClient gdoc.Client, gStorageClient gstorage.Client, templateFileId string, driveRootId string, adminEmail string, storagePath string, renderContext *entities.RenderContext, themes theme.Loader, lintConfig *entities.LintConfig, policies policy.Store, defaultRole string) Viewer {
+	httpClient := netguard.NewClient(httpClientTimeout)
+
 	return &viewerUsecase{
 		driveClient:    driveClient,
 		gDocClient:     gDocClient,
@@ -40,6 +99,14 @@ func NewViewer(driveClient gdrive.Client, gDocClient gdoc.Client, gStorageClient
 		driveRootId:    driveRootId,
 		adminEmail:     adminEmail,
 		storagePath:    storagePath,
+		renderContext:  newRenderContext(renderContext),
+		themes:         themes,
+		lintConfig:     newLintConfig(lintConfig),
+		policies:       policies,
+		defaultRole:    defaultRole,
+		httpClient:     httpClient,
+		linkChecker:    linkcheck.NewChecker(httpClient, linkCheckConcurrency, linkCheckHostInterval),
+		assets:         make(map[string][]byte),
 	}
 }
 
@@ -51,42 +118,123 @@ type viewerUsecase struct {
 	driveRootId    string
 	adminEmail     string
 	storagePath    string
+	renderContext  *entities.RenderContext
+	themes         theme.Loader
+	lintConfig     *entities.LintConfig
+	policies       policy.Store
+	defaultRole    string
+	httpClient     *http.Client
+	linkChecker    linkcheck.Checker
+	assetsMu       sync.Mutex
+	assets         map[string][]byte
 }
 
-func (uc *viewerUsecase) parseCodeLabs(ctx context.Context, fileId string) ([]byte, *entities.Meta, error) {
+func (uc *viewerUsecase) parseCodeLabs(ctx context.Context, fileId string) ([]byte, *entities.Meta, *types.Codelab, error) {
 	log := cp.Log(ctx, "ViewerUsecase.parseCodeLabs").WithField("fileId", fileId)
-	s, err := uc.driveClient.ExportFile(ctx, fileId, "text/html")
+	source, reader, err := uc.readSource(ctx, fileId)
 
 	if err != nil {
 		log.WithError(err).Error("google drive, get file failed")
-		return nil, nil, err
+		return nil, nil, nil, err
+	}
+
+	return uc.parseSource(ctx, fileId, source, reader)
+}
+
+// readSource opens the drive file, google docs are exported as html and markdown files are read as they are.
+func (uc *viewerUsecase) readSource(ctx context.Context, fileId string) (string, io.ReadCloser, error) {
+	f, err := uc.driveClient.StatFile(ctx, fileId)
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch {
+	case f.MimeType == gdrive.GoogleDocumentMimeType:
+		s, err := uc.driveClient.ExportFile(ctx, fileId, "text/html")
+		if err != nil {
+			return "", nil, err
+		}
+		return entities.SourceGoogleDoc, s.Reader, nil
+	case isMarkdownFile(f):
+		s, err := uc.driveClient.GetFile(ctx, fileId)
+		if err != nil {
+			return "", nil, err
+		}
+		return entities.SourceMarkdown, s.Reader, nil
+	}
+
+	return "", nil, ErrUnsupportedFile
+}
+
+func isMarkdownFile(f *gdrive.DriveFile) bool {
+	switch f.MimeType {
+	case "text/markdown", "text/x-markdown":
+		return true
+	}
+
+	ext := strings.ToLower(path.Ext(f.Name))
+	return ext == ".md" || ext == ".markdown"
+}
+
+// parseSource parses a google doc exported as html or a claat markdown file.
+func (uc *viewerUsecase) parseSource(ctx context.Context, fileId string, source string, reader io.ReadCloser) ([]byte, *entities.Meta, *types.Codelab, error) {
+	fetcher := fetch.NewGoogleDocMemoryFetcher(uc.passMetadata(), parser.Blackfriday)
+	if source == entities.SourceMarkdown {
+		fetcher = fetch.NewMemoryFetcher(uc.passMetadata(), parser.Blackfriday)
 	}
 
-	fetcher := fetch.NewGoogleDocMemoryFetcher(map[string]bool{}, parser.Blackfriday)
-	codelabs, err := fetcher.SlurpCodelab(s.Reader)
+	codelabs, err := fetcher.SlurpCodelab(reader)
 
 	if err != nil {
-		return nil, nil, errors.New("bad bad: " + err.Error())
+		return nil, nil, nil, errors.New("bad bad: " + err.Error())
 	}
 
 	var buffer bytes.Buffer
-	err = renderOutput(&buffer, codelabs.Codelab)
+	err = uc.renderOutput(ctx, &buffer, codelabs.Codelab)
 
 	meta := &entities.Meta{
 		FileId:       fileId,
 		Revision:     1, // default revision
+		Source:       source,
 		ExportedDate: time.Now(),
+		Render:       uc.codelabRenderContext(codelabs.Codelab),
 		Meta:         &codelabs.Meta,
 	}
 
-	return buffer.Bytes(), meta, err
+	return buffer.Bytes(), meta, codelabs.Codelab, err
 }
 
 func (uc *viewerUsecase) Parse(ctx context.Context, request *requests.ViewerParseRequest) (*requests.ViewerParseResponse, error) {
 	log := cp.Log(ctx, "ViewerUsecase.Parse").WithField("fileId", request.FileId)
 	defer stopwatch.StartWithLogger(log).Stop()
 
-	res, _, err := uc.parseCodeLabs(ctx, request.FileId)
+	format, output, err := lookupFormat(request.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := uc.authorizeView(ctx, request.FileId); err != nil {
+		return nil, err
+	}
+
+	res, _, codelab, err := uc.parseCodeLabs(ctx, request.FileId)
+
+	// the codelab is rendered again in the requested format, or with the previewed theme
+	if codelab != nil && (format != entities.FormatHTML || request.Theme != "") {
+		if request.Theme != "" {
+			if codelab.Extra == nil {
+				codelab.Extra = map[string]string{}
+			}
+			codelab.Extra[themeMetadata] = request.Theme
+		}
+
+		var buffer bytes.Buffer
+		err = uc.renderFormat(ctx, &buffer, codelab, format, 1)
+		if err == ErrUnknownTheme {
+			return nil, err
+		}
+		res = buffer.Bytes()
+	}
 
 	response := ""
 	if err != nil {
@@ -96,29 +244,13 @@ func (uc *viewerUsecase) Parse(ctx context.Context, request *requests.ViewerPars
 	}
 
 	return &requests.ViewerParseResponse{
-		Response: response,
+		Response:    response,
+		ContentType: output.ContentType,
 	}, nil
 }
 
-func renderOutput(w io.Writer, codelabs *types.Codelab) error {
-	data := &struct {
-		render.Context
-		Current *types.Step
-		StepNum int
-		Prev    bool
-		Next    bool
-	}{Context: render.Context{
-		Env:      "web",
-		Prefix:   "https://storage.googleapis.com",
-		Format:   "html",
-		GlobalGA: codelabs.GA,
-		Updated:  time.Now().Format(time.RFC3339),
-		Meta:     &codelabs.Meta,
-		Steps:    codelabs.Steps,
-		Extra:    map[string]string{},
-	}}
-
-	return render.Execute(w, "html", data)
+func (uc *viewerUsecase) renderOutput(ctx context.Context, w io.Writer, codelabs *types.Codelab) error {
+	return uc.renderFormat(ctx, w, codelabs, entities.FormatHTML, 0)
 }
 
 func (uc *viewerUsecase) Draft(ctx context.Context, request *requests.ViewerDraftRequest) (*requests.ViewerDraftResponse, error) {
@@ -129,13 +261,17 @@ func (uc *viewerUsecase) Draft(ctx context.Context, request *requests.ViewerDraf
 
 	if session == nil {
 		log.Errorf("get user session failed")
-		return nil, errors.New("unauthorized")
+		return nil, ErrUnauthorized
 	}
 
 	log.WithField("email", session.Email).
 		WithField("user_id", session.UserId).
 		Info("session found")
 
+	if err := uc.authorize(ctx, entities.RoleAuthor); err != nil {
+		return nil, err
+	}
+
 	if !request.Valid() {
 		log.Errorf("invalid request")
 		return nil, errors.New("bad request")
@@ -182,6 +318,11 @@ func (uc *viewerUsecase) Draft(ctx context.Context, request *requests.ViewerDraf
 		log.WithField("permission_id", s.Id).Info("owner set")
 	}
 
+	if _, err := uc.createOwnership(ctx, f.Id, session.Email); err != nil {
+		log.WithError(err).Error("record ownership failed")
+		return nil, err
+	}
+
 	// return to user
 	return &requests.ViewerDraftResponse{FileId: f.Id}, nil
 }
@@ -190,124 +331,740 @@ func (uc *viewerUsecase) Publish(ctx context.Context, request *requests.ViewerPu
 	log := cp.Log(ctx, "ViewerUsecase.Publish").WithField("fileId", request.FileId)
 	defer stopwatch.StartWithLogger(log).Stop()
 
+	if err := uc.authorize(ctx, entities.RoleAuthor); err != nil {
+		return nil, err
+	}
+
+	claim := claimDrive
+	if len(request.Markdown) > 0 {
+		claim = claimUpload
+	}
+
+	if err := uc.authorizeFile(ctx, request.FileId, claim); err != nil {
+		return nil, err
+	}
+
 	// parse codelabs
-	resBytes, meta, err := uc.parseCodeLabs(ctx, request.FileId)
+	var meta *entities.Meta
+	var codelab *types.Codelab
+	var err error
+
+	if len(request.Markdown) > 0 {
+		_, meta, codelab, err = uc.parseSource(ctx, request.FileId, entities.SourceMarkdown, ioutil.NopCloser(bytes.NewReader(request.Markdown)))
+	} else {
+		_, meta, codelab, err = uc.parseCodeLabs(ctx, request.FileId)
+	}
 
 	if err != nil {
 		return nil, err
 	}
 
-	// get latest revisions
-	latestMetaPath := fmt.Sprintf("%s/%s/latest/meta.json", uc.storagePath, request.FileId)
-	latestIndexPath := fmt.Sprintf("%s/%s/latest/index.html", uc.storagePath, request.FileId)
+	// codelabs with lint errors are refused, warnings are reported with the revision
+	issues := uc.lintCodelab(codelab)
+	if hasLintErrors(issues) {
+		log.WithField("issues", len(issues)).Error("lint failed")
+		return &requests.ViewerPublishResponse{Issues: issues}, ErrLintFailed
+	}
 
-	latestMetaBytes, err := uc.gStorageClient.Read(ctx, latestMetaPath)
-	if err != nil {
-		if !gstorage.IsNotExistError(err) {
-			log.WithError(err).WithField("path", latestMetaPath).Error("read latest meta file failed")
-			return nil, err
-		}
-	} else {
-		latestMeta := &entities.Meta{}
-		mm := latestMetaBytes.Bytes()
-		if e := json.Unmarshal(mm, latestMeta); e != nil {
-			log.WithError(err).WithField("data", string(mm)).Error("unmarshal latest meta file failed")
-		} else {
-			log.WithField("revision", latestMeta.Revision).Error("latest revision")
-			meta.Revision = latestMeta.Revision + 1
+	if request.CheckLinks {
+		if broken := brokenLinks(uc.checkLinks(ctx, codelab)); len(broken) > 0 {
+			log.WithField("broken", len(broken)).Error("broken links")
+			return &requests.ViewerPublishResponse{Issues: issues, Links: broken}, ErrBrokenLinks
 		}
 	}
 
-	revMetaPath := fmt.Sprintf("%s/%s/%d/meta.json", uc.storagePath, request.FileId, meta.Revision)
-	revIndexPath := fmt.Sprintf("%s/%s/%d/index.html", uc.storagePath, request.FileId, meta.Revision)
+	if session := getSession(ctx); session != nil {
+		meta.PublishedBy = session.Email
+	}
+
+	summarizeContent(meta, codelab)
 
-	// save new revision to bucket
-	size, err := uc.gStorageClient.Write(ctx, latestIndexPath, bytes.NewBuffer(resBytes))
+	// next revision, latest may point at an older promoted revision so it follows the highest revision instead
+	highest, err := uc.highestRevision(ctx, request.FileId)
 	if err != nil {
-		log.WithError(err).WithField("path", latestIndexPath).Error("write index file failed")
+		log.WithError(err).Error("list revisions failed")
+		return nil, err
+	}
+
+	if highest > 0 {
+		log.WithField("revision", highest).Info("highest revision")
+		meta.Revision = highest + 1
+	}
+
+	// claim the revision, the revision meta file can only be created once
+	if err := uc.claimRevision(ctx, meta); err != nil {
+		log.WithError(err).WithField("revision", meta.Revision).Error("claim revision failed")
 		return nil, err
 	}
-	log.WithField("size", size).WithField("path", latestIndexPath).Info("latest index file created")
-	size, err = uc.gStorageClient.Write(ctx, revIndexPath, bytes.NewBuffer(resBytes))
+
+	// stage the revision, latest is left untouched until every revision file is written
+	if err := uc.storeImages(ctx, request.FileId, meta.Revision, codelab); err != nil {
+		log.WithError(err).Error("store images failed")
+		uc.removeRevision(ctx, request.FileId, meta.Revision)
+		return nil, err
+	}
+
+	codelabBytes, err := codelabEncoding.Marshal(codelab)
 	if err != nil {
-		log.WithError(err).WithField("path", revIndexPath).Error("write revision index file failed")
+		log.WithError(err).Error("marshal codelab failed")
+		uc.removeRevision(ctx, request.FileId, meta.Revision)
 		return nil, err
 	}
-	log.WithField("size", size).WithField("path", revIndexPath).Info("revision index file created")
-	size, err = uc.gStorageClient.Write(ctx, latestMetaPath, bytes.NewBufferString(utils.StringifyIndent(meta)))
+
+	revCodelabPath := uc.objectPath(request.FileId, meta.Revision, codelabFileName)
+	size, err := uc.gStorageClient.Write(ctx, revCodelabPath, bytes.NewBuffer(codelabBytes))
 	if err != nil {
-		log.WithError(err).WithField("path", latestMetaPath).Error("write latest meta file failed")
+		log.WithError(err).WithField("path", revCodelabPath).Error("write revision codelab file failed")
+		uc.removeRevision(ctx, request.FileId, meta.Revision)
+		return nil, err
+	}
+	log.WithField("size", size).WithField("path", revCodelabPath).Info("revision codelab file created")
+
+	// render again, images now point at the stored copies
+	var buffer bytes.Buffer
+	if err := uc.renderOutput(ctx, &buffer, codelab); err != nil {
+		log.WithError(err).Error("render codelab failed")
+		uc.removeRevision(ctx, request.FileId, meta.Revision)
 		return nil, err
 	}
-	log.WithField("size", size).WithField("path", latestMetaPath).Info("latest meta file created")
-	size, err = uc.gStorageClient.Write(ctx, revMetaPath, bytes.NewBufferString(utils.StringifyIndent(meta)))
+
+	revIndexPath := uc.objectPath(request.FileId, meta.Revision, indexFileName)
+	size, err = uc.gStorageClient.Write(ctx, revIndexPath, &buffer)
 	if err != nil {
-		log.WithError(err).WithField("path", revMetaPath).Error("write revision meta file failed")
+		log.WithError(err).WithField("path", revIndexPath).Error("write revision index file failed")
+		uc.removeRevision(ctx, request.FileId, meta.Revision)
+		return nil, err
+	}
+	log.WithField("size", size).WithField("path", revIndexPath).Info("revision index file created")
+
+	// flip latest
+	if err := uc.flipLatest(ctx, meta); err != nil {
+		log.WithError(err).Error("flip latest failed")
+		uc.removeRevision(ctx, request.FileId, meta.Revision)
 		return nil, err
 	}
-	log.WithField("size", size).WithField("path", revMetaPath).Info("revision meta file created")
 
 	return &requests.ViewerPublishResponse{
 		Revision: meta.Revision,
+		Issues:   issues,
 	}, nil
 
 }
 
+// claimRevision creates the revision meta file of meta.Revision, moving on to the next revision
+// when another publish got there first.
+func (uc *viewerUsecase) claimRevision(ctx context.Context, meta *entities.Meta) error {
+	log := cp.Log(ctx, "ViewerUsecase.claimRevision").WithField("fileId", meta.FileId)
+
+	for attempt := 0; attempt < maxClaimRevisionAttempts; attempt++ {
+		revMetaPath := uc.objectPath(meta.FileId, meta.Revision, metaFileName)
+		size, err := uc.gStorageClient.WriteIfGenerationMatch(ctx, revMetaPath, bytes.NewBufferString(utils.StringifyIndent(meta)), 0)
+
+		if err == nil {
+			log.WithField("size", size).WithField("path", revMetaPath).Info("revision meta file created")
+			return nil
+		}
+
+		if !gstorage.IsPreconditionFailedError(err) {
+			log.WithError(err).WithField("path", revMetaPath).Error("write revision meta file failed")
+			return err
+		}
+
+		log.WithField("revision", meta.Revision).Warn("revision already taken")
+		meta.Revision++
+	}
+
+	return ErrRevisionConflict
+}
+
+// flipLatest points latest to meta.Revision, unless a newer revision has been made latest in the meantime.
+// The latest meta file is the only object written, so readers always see a complete revision.
+func (uc *viewerUsecase) flipLatest(ctx context.Context, meta *entities.Meta) error {
+	log := cp.Log(ctx, "ViewerUsecase.flipLatest").WithField("fileId", meta.FileId).WithField("revision", meta.Revision)
+	latestMetaPath := uc.objectPath(meta.FileId, 0, metaFileName)
+
+	for attempt := 0; attempt < maxFlipLatestAttempts; attempt++ {
+		generation := int64(0)
+		attrs, err := uc.gStorageClient.Stat(ctx, latestMetaPath)
+		if err == nil {
+			generation = attrs.Generation
+			if latestMeta, e := uc.readMeta(ctx, latestMetaPath); e == nil {
+				if latestMeta.Revision >= meta.Revision {
+					log.WithField("latest", latestMeta.Revision).Info("newer revision is already latest")
+					return nil
+				}
+				// visibility belongs to the codelab, not to a revision
+				meta.Visibility = latestMeta.Visibility
+			}
+		} else if !gstorage.IsNotExistError(err) {
+			return err
+		}
+
+		size, err := uc.gStorageClient.WriteIfGenerationMatch(ctx, latestMetaPath, bytes.NewBufferString(utils.StringifyIndent(meta)), generation)
+		if err == nil {
+			log.WithField("size", size).WithField("path", latestMetaPath).Info("latest meta file created")
+			return nil
+		}
+
+		if !gstorage.IsPreconditionFailedError(err) {
+			return err
+		}
+
+		log.Warn("latest changed, retrying")
+	}
+
+	return ErrRevisionConflict
+}
+
+// removeRevision cleans up the files of a partially published revision.
+func (uc *viewerUsecase) removeRevision(ctx context.Context, fileId string, revision int) {
+	log := cp.Log(ctx, "ViewerUsecase.removeRevision").WithField("fileId", fileId).WithField("revision", revision)
+
+	if err := uc.deleteImages(ctx, fileId, revision); err != nil {
+		log.WithError(err).Error("delete revision images failed")
+	}
+
+	if err := uc.deleteFormats(ctx, fileId, revision); err != nil {
+		log.WithError(err).Error("delete revision formats failed")
+	}
+
+	for _, name := range []string{indexFileName, codelabFileName, metaFileName} {
+		path := uc.objectPath(fileId, revision, name)
+		if err := uc.gStorageClient.Delete(ctx, path); err != nil && !gstorage.IsNotExistError(err) {
+			log.WithError(err).WithField("path", path).Error("delete revision file failed")
+		}
+	}
+}
+
+// resolveRevision returns the revision latest points to when revision is not positive.
+// Latest is resolved through its meta file, so index and meta always belong to the same revision.
+func (uc *viewerUsecase) resolveRevision(ctx context.Context, fileId string, revision int) (int, error) {
+	if revision > 0 {
+		return revision, nil
+	}
+
+	latestMeta, err := uc.readMeta(ctx, uc.objectPath(fileId, 0, metaFileName))
+	if err != nil {
+		return 0, err
+	}
+
+	if latestMeta.Deleted() {
+		return 0, ErrNotFound
+	}
+
+	return latestMeta.Revision, nil
+}
+
+func (uc *viewerUsecase) readMeta(ctx context.Context, path string) (*entities.Meta, error) {
+	metaBytes, err := uc.gStorageClient.Read(ctx, path)
+
+	if err != nil {
+		if gstorage.IsNotExistError(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	meta := &entities.Meta{}
+	if err := json.Unmarshal(metaBytes.Bytes(), meta); err != nil {
+		return nil, err
+	}
+
+	return meta, nil
+}
+
+func (uc *viewerUsecase) objectPath(fileId string, revision int, name string) string {
+	if revision <= 0 {
+		return fmt.Sprintf("%s/%s/latest/%s", uc.storagePath, fileId, name)
+	}
+
+	return fmt.Sprintf("%s/%s/%d/%s", uc.storagePath, fileId, revision, name)
+}
+
 func (uc *viewerUsecase) View(ctx context.Context, request *requests.ViewerViewRequest) (*requests.ViewerViewResponse, error) {
 	log := cp.Log(ctx, "ViewerUsecase.View").WithField("fileId", request.FileId).WithField("revision", request.Revision)
 	defer stopwatch.StartWithLogger(log).Stop()
 
-	path := ""
-	if request.Revision <= 0 {
-		path = fmt.Sprintf("%s/%s/latest/index.html", uc.storagePath, request.FileId)
-	} else {
-		path = fmt.Sprintf("%s/%s/%d/index.html", uc.storagePath, request.FileId, request.Revision)
+	format, output, err := lookupFormat(request.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := uc.authorizeView(ctx, request.FileId); err != nil {
+		return nil, err
+	}
+
+	revision, err := uc.resolveRevision(ctx, request.FileId, request.Revision)
+	if err != nil {
+		log.WithError(err).Error("resolve revision failed")
+		return nil, err
+	}
+
+	if format != entities.FormatHTML {
+		res, err := uc.viewFormat(ctx, request.FileId, revision, format, request.Page, request.Rerender)
+		if err != nil {
+			return nil, err
+		}
+
+		return &requests.ViewerViewResponse{Response: string(res), ContentType: output.ContentType}, nil
 	}
 
+	if request.Rerender {
+		res, err := uc.rerender(ctx, request.FileId, revision)
+		if err == nil {
+			return &requests.ViewerViewResponse{Response: string(res), ContentType: output.ContentType}, nil
+		}
+
+		if err != ErrNotFound {
+			log.WithError(err).Error("rerender failed")
+			return nil, err
+		}
+
+		// revisions published before codelab models were stored can only be served as they are
+		log.Info("codelab file not found, serving stored index")
+	}
+
+	path := uc.objectPath(request.FileId, revision, indexFileName)
+
 	indexBytes, err := uc.gStorageClient.Read(ctx, path)
 
 	if err != nil {
 		log.WithError(err).WithField("path", path).Error("read index file failed")
 		if gstorage.IsNotExistError(err) {
 
-			return nil, errors.New("not found")
+			return nil, ErrNotFound
 		} else {
 			return nil, err
 		}
 	}
 
-	return &requests.ViewerViewResponse{Response: indexBytes.String()}, nil
+	return &requests.ViewerViewResponse{Response: indexBytes.String(), ContentType: output.ContentType}, nil
+}
+
+// rerender renders the stored codelab model of the revision with the current template.
+func (uc *viewerUsecase) rerender(ctx context.Context, fileId string, revision int) ([]byte, error) {
+	codelab, err := uc.readCodelab(ctx, fileId, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	if err := uc.renderOutput(ctx, &buffer, codelab); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func (uc *viewerUsecase) readCodelab(ctx context.Context, fileId string, revision int) (*types.Codelab, error) {
+	codelabBytes, err := uc.gStorageClient.Read(ctx, uc.objectPath(fileId, revision, codelabFileName))
+
+	if err != nil {
+		if gstorage.IsNotExistError(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return codelabEncoding.Unmarshal(codelabBytes.Bytes())
 }
 
 func (uc *viewerUsecase) Meta(ctx context.Context, request *requests.ViewerMetaRequest) (*requests.ViewerMetaResponse, error) {
 	log := cp.Log(ctx, "ViewerUsecase.Meta").WithField("fileId", request.FileId).WithField("revision", request.Revision)
 	defer stopwatch.StartWithLogger(log).Stop()
 
-	path := ""
-	if request.Revision <= 0 {
-		path = fmt.Sprintf("%s/%s/latest/meta.json", uc.storagePath, request.FileId)
-	} else {
-		path = fmt.Sprintf("%s/%s/%d/meta.json", uc.storagePath, request.FileId, request.Revision)
+	if err := uc.authorize(ctx, entities.RoleViewer); err != nil {
+		return nil, err
 	}
 
-	metaBytes, err := uc.gStorageClient.Read(ctx, path)
+	if err := uc.authorizeView(ctx, request.FileId); err != nil {
+		return nil, err
+	}
+
+	path := uc.objectPath(request.FileId, request.Revision, metaFileName)
+
+	meta, err := uc.readMeta(ctx, path)
 
 	if err != nil {
 		log.WithError(err).WithField("path", path).Error("read meta file failed")
+		return nil, err
+	}
+
+	if meta.Deleted() {
+		return nil, ErrNotFound
+	}
+
+	return &requests.ViewerMetaResponse{Meta: meta}, nil
+}
+
+func (uc *viewerUsecase) Revisions(ctx context.Context, request *requests.ViewerRevisionsRequest) (*requests.ViewerRevisionsResponse, error) {
+	log := cp.Log(ctx, "ViewerUsecase.Revisions").WithField("fileId", request.FileId)
+	defer stopwatch.StartWithLogger(log).Stop()
+
+	if err := uc.authorize(ctx, entities.RoleViewer); err != nil {
+		return nil, err
+	}
+
+	if err := uc.authorizeView(ctx, request.FileId); err != nil {
+		return nil, err
+	}
+
+	revisions, err := uc.listRevisions(ctx, request.FileId)
+
+	if err != nil {
+		log.WithError(err).Error("list revisions failed")
+		return nil, err
+	}
+
+	limit := request.Limit
+	if limit <= 0 {
+		limit = defaultRevisionsLimit
+	} else if limit > maxRevisionsLimit {
+		limit = maxRevisionsLimit
+	}
+
+	offset := request.Offset
+	if offset < 0 {
+		offset = 0
+	} else if offset > len(revisions) {
+		offset = len(revisions)
+	}
+
+	end := offset + limit
+	if end > len(revisions) {
+		end = len(revisions)
+	}
+
+	metas := make([]*entities.Meta, 0, end-offset)
+	for _, revision := range revisions[offset:end] {
+		path := uc.objectPath(request.FileId, revision, metaFileName)
+		meta, err := uc.readMeta(ctx, path)
+
+		if err != nil {
+			log.WithError(err).WithField("path", path).Error("read meta file failed")
+			return nil, err
+		}
+
+		metas = append(metas, meta)
+	}
+
+	return &requests.ViewerRevisionsResponse{
+		Revisions: metas,
+		Total:     len(revisions),
+	}, nil
+}
+
+// listRevisions returns every completely published revision of the file, newest first.
+func (uc *viewerUsecase) listRevisions(ctx context.Context, fileId string) ([]int, error) {
+	files, err := uc.listRevisionFiles(ctx, fileId)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := make([]int, 0, len(files))
+	for revision, names := range files {
+		// a revision without its index is still being staged
+		if names[indexFileName] && names[metaFileName] {
+			revisions = append(revisions, revision)
+		}
+	}
+
+	sort.Sort(sort.Reverse(sort.IntSlice(revisions)))
+
+	return revisions, nil
+}
+
+// highestRevision returns the highest revision ever claimed for the file, including revisions being staged
+// and deleted revisions, zero when none.
+func (uc *viewerUsecase) highestRevision(ctx context.Context, fileId string) (int, error) {
+	files, err := uc.listRevisionFiles(ctx, fileId)
+	if err != nil {
+		return 0, err
+	}
+
+	highest := 0
+	for revision := range files {
+		if revision > highest {
+			highest = revision
+		}
+	}
+
+	return highest, nil
+}
+
+// listRevisionFiles returns the names of the files stored for each revision of the file.
+func (uc *viewerUsecase) listRevisionFiles(ctx context.Context, fileId string) (map[int]map[string]bool, error) {
+	prefix := fmt.Sprintf("%s/%s/", uc.storagePath, fileId)
+	objects, err := uc.gStorageClient.List(ctx, prefix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[int]map[string]bool)
+	for _, o := range objects {
+		parts := strings.SplitN(strings.TrimPrefix(o.Name, prefix), "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		revision, err := strconv.Atoi(parts[0])
+		if err != nil || revision <= 0 {
+			continue
+		}
+
+		if files[revision] == nil {
+			files[revision] = make(map[string]bool)
+		}
+		files[revision][parts[1]] = true
+	}
+
+	return files, nil
+}
+
+func (uc *viewerUsecase) Promote(ctx context.Context, request *requests.ViewerPromoteRequest) (*requests.ViewerPromoteResponse, error) {
+	log := cp.Log(ctx, "ViewerUsecase.Promote").WithField("fileId", request.FileId).WithField("revision", request.Revision)
+	defer stopwatch.StartWithLogger(log).Stop()
+
+	session := getSession(ctx)
+
+	if session == nil {
+		log.Errorf("get user session failed")
+		return nil, ErrUnauthorized
+	}
+
+	if err := uc.authorize(ctx, entities.RoleReviewer); err != nil {
+		return nil, err
+	}
+
+	if err := uc.authorizeFile(ctx, request.FileId, claimNone); err != nil {
+		return nil, err
+	}
+
+	if request.Revision <= 0 {
+		return nil, ErrNotFound
+	}
+
+	// only completely published revisions can be promoted
+	revIndexPath := uc.objectPath(request.FileId, request.Revision, indexFileName)
+	if _, err := uc.gStorageClient.Stat(ctx, revIndexPath); err != nil {
+		log.WithError(err).WithField("path", revIndexPath).Error("stat revision index file failed")
 		if gstorage.IsNotExistError(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
 
-			return nil, errors.New("not found")
-		} else {
+	revMetaPath := uc.objectPath(request.FileId, request.Revision, metaFileName)
+	meta, err := uc.readMeta(ctx, revMetaPath)
+	if err != nil {
+		log.WithError(err).WithField("path", revMetaPath).Error("read revision meta file failed")
+		return nil, err
+	}
+
+	now := time.Now()
+	meta.PromotedBy = session.Email
+	meta.PromotedDate = &now
+
+	latestMetaPath := uc.objectPath(request.FileId, 0, metaFileName)
+
+	for attempt := 0; attempt < maxFlipLatestAttempts; attempt++ {
+		generation := int64(0)
+		attrs, err := uc.gStorageClient.Stat(ctx, latestMetaPath)
+		if err == nil {
+			generation = attrs.Generation
+			latestMeta, err := uc.readMeta(ctx, latestMetaPath)
+			if err != nil && err != ErrNotFound {
+				log.WithError(err).WithField("path", latestMetaPath).Error("read latest meta file failed")
+				return nil, err
+			}
+			if latestMeta != nil {
+				meta.Visibility = latestMeta.Visibility
+			}
+		} else if !gstorage.IsNotExistError(err) {
+			log.WithError(err).WithField("path", latestMetaPath).Error("stat latest meta file failed")
+			return nil, err
+		}
+
+		size, err := uc.gStorageClient.WriteIfGenerationMatch(ctx, latestMetaPath, bytes.NewBufferString(utils.StringifyIndent(meta)), generation)
+		if err == nil {
+			log.WithField("size", size).WithField("path", latestMetaPath).WithField("email", session.Email).Info("revision promoted")
+			return &requests.ViewerPromoteResponse{Revision: meta.Revision}, nil
+		}
+
+		if !gstorage.IsPreconditionFailedError(err) {
+			log.WithError(err).WithField("path", latestMetaPath).Error("write latest meta file failed")
 			return nil, err
 		}
+
+		log.Warn("latest changed, retrying")
 	}
 
-	meta := &entities.Meta{}
-	mm := metaBytes.Bytes()
-	if e := json.Unmarshal(mm, meta); e != nil {
-		log.WithError(err).WithField("data", string(mm)).Error("unmarshal meta file failed")
+	return nil, ErrRevisionConflict
+}
+
+func (uc *viewerUsecase) Delete(ctx context.Context, request *requests.ViewerDeleteRequest) (*requests.ViewerDeleteResponse, error) {
+	log := cp.Log(ctx, "ViewerUsecase.Delete").WithField("fileId", request.FileId).WithField("revision", request.Revision)
+	defer stopwatch.StartWithLogger(log).Stop()
+
+	session := getSession(ctx)
+
+	if session == nil {
+		log.Errorf("get user session failed")
+		return nil, ErrUnauthorized
+	}
+
+	if err := uc.authorize(ctx, entities.RoleReviewer); err != nil {
 		return nil, err
 	}
 
-	return &requests.ViewerMetaResponse{Meta: meta}, nil
+	if err := uc.authorizeFile(ctx, request.FileId, claimNone); err != nil {
+		return nil, err
+	}
+
+	if request.Revision > 0 {
+		if err := uc.deleteRevision(ctx, request.FileId, request.Revision, session.Email); err != nil {
+			return nil, err
+		}
+	}
+
+	unpublished, err := uc.unpublishLatest(ctx, request.FileId, request.Revision, session.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	// deleting a revision not served as latest keeps the codelab published
+	if !unpublished {
+		if request.Revision > 0 {
+			return &requests.ViewerDeleteResponse{}, nil
+		}
+		return nil, ErrNotFound
+	}
+
+	// earlier versions kept a copy of the latest index
+	latestIndexPath := uc.objectPath(request.FileId, 0, indexFileName)
+	if err := uc.gStorageClient.Delete(ctx, latestIndexPath); err != nil && !gstorage.IsNotExistError(err) {
+		log.WithError(err).WithField("path", latestIndexPath).Error("delete latest index file failed")
+		return nil, err
+	}
+
+	if request.Revision > 0 || request.KeepHistory {
+		return &requests.ViewerDeleteResponse{}, nil
+	}
+
+	revisions, err := uc.listRevisions(ctx, request.FileId)
+	if err != nil {
+		log.WithError(err).Error("list revisions failed")
+		return nil, err
+	}
+
+	for _, revision := range revisions {
+		if err := uc.deleteRevision(ctx, request.FileId, revision, session.Email); err != nil {
+			return nil, err
+		}
+	}
+
+	return &requests.ViewerDeleteResponse{}, nil
+}
+
+// unpublishLatest marks latest as deleted when it serves revision, any revision when revision is 0.
+// The write is checked against the generation read so that concurrent visibility changes are kept.
+func (uc *viewerUsecase) unpublishLatest(ctx context.Context, fileId string, revision int, email string) (bool, error) {
+	log := cp.Log(ctx, "ViewerUsecase.unpublishLatest").WithField("fileId", fileId).WithField("revision", revision)
+	latestMetaPath := uc.objectPath(fileId, 0, metaFileName)
+
+	for attempt := 0; attempt < maxFlipLatestAttempts; attempt++ {
+		attrs, err := uc.gStorageClient.Stat(ctx, latestMetaPath)
+		if err != nil {
+			if gstorage.IsNotExistError(err) {
+				return false, nil
+			}
+			log.WithError(err).WithField("path", latestMetaPath).Error("stat latest meta file failed")
+			return false, err
+		}
+
+		latestMeta, err := uc.readMeta(ctx, latestMetaPath)
+		if err == ErrNotFound {
+			return false, nil
+		}
+		if err != nil {
+			log.WithError(err).WithField("path", latestMetaPath).Error("read latest meta file failed")
+			return false, err
+		}
+
+		if latestMeta.Deleted() || (revision > 0 && latestMeta.Revision != revision) {
+			return false, nil
+		}
+
+		now := time.Now()
+		latestMeta.DeletedBy = email
+		latestMeta.DeletedDate = &now
+
+		size, err := uc.gStorageClient.WriteIfGenerationMatch(ctx, latestMetaPath, bytes.NewBufferString(utils.StringifyIndent(latestMeta)), attrs.Generation)
+		if err == nil {
+			log.WithField("size", size).WithField("path", latestMetaPath).WithField("email", email).Info("codelab unpublished")
+			return true, nil
+		}
+
+		if !gstorage.IsPreconditionFailedError(err) {
+			log.WithError(err).WithField("path", latestMetaPath).Error("write latest meta file failed")
+			return false, err
+		}
+
+		log.Warn("latest changed, retrying")
+	}
+
+	return false, ErrRevisionConflict
+}
+
+// deleteRevision removes the revision content and turns its meta file into a tombstone. The index goes first so that
+// a delete failing midway never leaves a readable revision, and the tombstone last so that the delete can be retried.
+func (uc *viewerUsecase) deleteRevision(ctx context.Context, fileId string, revision int, email string) error {
+	log := cp.Log(ctx, "ViewerUsecase.deleteRevision").WithField("fileId", fileId).WithField("revision", revision)
+
+	revMetaPath := uc.objectPath(fileId, revision, metaFileName)
+	meta, err := uc.readMeta(ctx, revMetaPath)
+	if err != nil {
+		log.WithError(err).WithField("path", revMetaPath).Error("read revision meta file failed")
+		return err
+	}
+
+	if meta.Deleted() {
+		return ErrNotFound
+	}
+
+	revIndexPath := uc.objectPath(fileId, revision, indexFileName)
+	if err := uc.gStorageClient.Delete(ctx, revIndexPath); err != nil && !gstorage.IsNotExistError(err) {
+		log.WithError(err).WithField("path", revIndexPath).Error("delete revision index file failed")
+		return err
+	}
+
+	revCodelabPath := uc.objectPath(fileId, revision, codelabFileName)
+	if err := uc.gStorageClient.Delete(ctx, revCodelabPath); err != nil && !gstorage.IsNotExistError(err) {
+		log.WithError(err).WithField("path", revCodelabPath).Error("delete revision codelab file failed")
+		return err
+	}
+
+	if err := uc.deleteImages(ctx, fileId, revision); err != nil {
+		log.WithError(err).Error("delete revision images failed")
+		return err
+	}
+
+	if err := uc.deleteFormats(ctx, fileId, revision); err != nil {
+		log.WithError(err).Error("delete revision formats failed")
+		return err
+	}
+
+	now := time.Now()
+	meta.DeletedBy = email
+	meta.DeletedDate = &now
+
+	if _, err := uc.gStorageClient.Write(ctx, revMetaPath, bytes.NewBufferString(utils.StringifyIndent(meta))); err != nil {
+		log.WithError(err).WithField("path", revMetaPath).Error("write revision meta file failed")
+		return err
+	}
+
+	log.WithField("email", email).Info("revision deleted")
+
+	return nil
 }
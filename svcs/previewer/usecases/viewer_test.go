@@ -0,0 +1,1055 @@
+package usecases
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/foxfoxio/codelabs-preview-go/internal/codelab"
+	"github.com/foxfoxio/codelabs-preview-go/internal/ctx_helper"
+	"github.com/foxfoxio/codelabs-preview-go/internal/diff"
+	"github.com/foxfoxio/codelabs-preview-go/internal/gdrive"
+	"github.com/foxfoxio/codelabs-preview-go/internal/gstorage"
+	"github.com/foxfoxio/codelabs-preview-go/internal/linkcheck"
+	"github.com/foxfoxio/codelabs-preview-go/internal/netguard"
+	"github.com/foxfoxio/codelabs-preview-go/internal/policy"
+	"github.com/foxfoxio/codelabs-preview-go/internal/theme"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities/requests"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/googleapi"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+const testCodelabDoc = `<html><head><style>.meta { color: #b7b7b7 }</style></head><body>
+<p class="title"><span>Test Codelab</span></p>
+<table>
+<tr><td><p>Summary</p></td><td><p>a summary</p></td></tr>
+<tr><td><p>id</p></td><td><p>test-codelab</p></td></tr>
+</table>
+<h1><span>Overview</span></h1>
+<p><span class="meta">Duration: 1:00</span></p>
+<p><span>hello world</span></p>
+</body></html>`
+
+const testCodelabMarkdown = `summary: a summary
+id: markdown-codelab
+
+# Markdown Codelab
+
+## Overview
+Duration: 1:00
+
+hello markdown
+`
+
+type fakeDriveClient struct {
+	gdrive.Client
+	docs        map[string]string
+	permissions map[string]string
+	// owners are the drive owners of the documents, the service account when missing
+	owners map[string]string
+}
+
+func (c *fakeDriveClient) StatFile(ctx context.Context, fileId string) (*gdrive.DriveFile, error) {
+	if strings.HasSuffix(fileId, ".md") {
+		return &gdrive.DriveFile{Id: fileId, Name: fileId, MimeType: "text/plain"}, nil
+	}
+	if _, ok := c.docs[fileId]; !ok {
+		return nil, &googleapi.Error{Code: http.StatusNotFound}
+	}
+	f := &gdrive.DriveFile{Id: fileId, Name: fileId, MimeType: gdrive.GoogleDocumentMimeType, Owners: []string{}}
+	if owner, ok := c.owners[fileId]; ok {
+		f.Owners = append(f.Owners, owner)
+	}
+	return f, nil
+}
+
+func (c *fakeDriveClient) GetFile(ctx context.Context, fileId string) (*gdrive.DriveFileReader, error) {
+	return &gdrive.DriveFileReader{Reader: ioutil.NopCloser(strings.NewReader(c.docs[fileId]))}, nil
+}
+
+func (c *fakeDriveClient) ExportFile(ctx context.Context, fileId string, mimeType string) (*gdrive.DriveFileReader, error) {
+	return &gdrive.DriveFileReader{Reader: ioutil.NopCloser(strings.NewReader(c.docs[fileId]))}, nil
+}
+
+func (c *fakeDriveClient) GrantWritePermission(ctx context.Context, fileId string, userEmail string) (*gdrive.DrivePermission, error) {
+	id := fmt.Sprintf("perm-%d", len(c.permissions)+1)
+	c.permissions[id] = fileId + "/" + userEmail
+	return &gdrive.DrivePermission{Id: id}, nil
+}
+
+func (c *fakeDriveClient) RevokePermission(ctx context.Context, fileId string, permissionId string) error {
+	delete(c.permissions, permissionId)
+	return nil
+}
+
+// authorContext returns a context with the session of the author publishing the test codelabs.
+func authorContext() context.Context {
+	return ctx_helper.AppendSession(context.Background(), &entities.UserSession{Email: "author@example.com"})
+}
+
+func newTestViewer(storage gstorage.Client) *viewerUsecase {
+	driveClient := &fakeDriveClient{docs: map[string]string{"doc": testCodelabDoc}, permissions: map[string]string{}}
+	return NewViewer(driveClient, nil, storage, "", "", "", "files-test", nil, nil, nil, nil, "").(*viewerUsecase)
+}
+
+func TestViewerPublish(t *testing.T) {
+	ctx := authorContext()
+	uc := newTestViewer(gstorage.NewMemoryClient())
+
+	for i := 1; i <= 3; i++ {
+		res, err := uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "doc"})
+		assert.NoError(t, err)
+		assert.Equal(t, i, res.Revision)
+	}
+
+	metaRes, err := uc.Meta(ctx, &requests.ViewerMetaRequest{FileId: "doc"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, metaRes.Meta.Revision)
+	assert.Equal(t, "Test Codelab", metaRes.Meta.Meta.Title)
+
+	viewRes, err := uc.View(ctx, &requests.ViewerViewRequest{FileId: "doc", Revision: 2})
+	assert.NoError(t, err)
+	assert.Contains(t, viewRes.Response, "hello world")
+}
+
+// racyStorage lets the first revision listings all happen before any publish writes.
+type racyStorage struct {
+	gstorage.Client
+	barrier *sync.WaitGroup
+	reads   int32
+	readers int32
+}
+
+func (s *racyStorage) List(ctx context.Context, prefix string) ([]*gstorage.ObjectAttrs, error) {
+	objects, err := s.Client.List(ctx, prefix)
+	if atomic.AddInt32(&s.reads, 1) <= s.readers {
+		s.barrier.Done()
+		s.barrier.Wait()
+	}
+	return objects, err
+}
+
+// staleStorage lists no objects, like a listing that does not see recent writes yet.
+type staleStorage struct {
+	gstorage.Client
+}
+
+func (s *staleStorage) List(ctx context.Context, prefix string) ([]*gstorage.ObjectAttrs, error) {
+	return nil, nil
+}
+
+// failingStorage fails every write of objects with the given suffix.
+type failingStorage struct {
+	gstorage.Client
+	suffix string
+}
+
+func (s *failingStorage) Write(ctx context.Context, object string, content io.Reader) (int64, error) {
+	if strings.HasSuffix(object, s.suffix) {
+		return 0, errors.New("write failed")
+	}
+	return s.Client.Write(ctx, object, content)
+}
+
+func (s *failingStorage) WriteIfGenerationMatch(ctx context.Context, object string, content io.Reader, generation int64) (int64, error) {
+	if strings.HasSuffix(object, s.suffix) {
+		return 0, errors.New("write failed")
+	}
+	return s.Client.WriteIfGenerationMatch(ctx, object, content, generation)
+}
+
+// failingDeleteStorage fails every delete of objects with the given suffix.
+type failingDeleteStorage struct {
+	gstorage.Client
+	suffix string
+}
+
+func (s *failingDeleteStorage) Delete(ctx context.Context, object string) error {
+	if strings.HasSuffix(object, s.suffix) {
+		return errors.New("delete failed")
+	}
+	return s.Client.Delete(ctx, object)
+}
+
+func TestViewerPublishConcurrent(t *testing.T) {
+	const publishes = 8
+
+	ctx := authorContext()
+	barrier := &sync.WaitGroup{}
+	barrier.Add(publishes)
+	uc := newTestViewer(&racyStorage{Client: gstorage.NewMemoryClient(), barrier: barrier, readers: publishes})
+
+	revisions := make([]int, publishes)
+	errs := make([]error, publishes)
+
+	var wg sync.WaitGroup
+	for i := 0; i < publishes; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res, err := uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "doc"})
+			errs[i] = err
+			if err == nil {
+				revisions[i] = res.Revision
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+
+	sort.Ints(revisions)
+	for i, rev := range revisions {
+		assert.Equal(t, i+1, rev, "every publish must get its own revision")
+	}
+
+	metaRes, err := uc.Meta(ctx, &requests.ViewerMetaRequest{FileId: "doc"})
+	assert.NoError(t, err)
+	assert.Equal(t, publishes, metaRes.Meta.Revision, "latest must end up on the newest revision")
+}
+
+func TestViewerPublishRollback(t *testing.T) {
+	ctx := authorContext()
+	storage := gstorage.NewMemoryClient()
+	uc := newTestViewer(storage)
+
+	_, err := uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "doc"})
+	assert.NoError(t, err)
+
+	for _, suffix := range []string{"2/index.html", "latest/meta.json"} {
+		failing := newTestViewer(&failingStorage{Client: storage, suffix: suffix})
+		_, err = failing.Publish(ctx, &requests.ViewerPublishRequest{FileId: "doc"})
+		assert.Error(t, err)
+
+		objects, err := storage.List(ctx, "files-test/doc/2/")
+		assert.NoError(t, err)
+		assert.Empty(t, objects, "partial revision must be cleaned up")
+
+		metaRes, err := uc.Meta(ctx, &requests.ViewerMetaRequest{FileId: "doc"})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, metaRes.Meta.Revision)
+
+		_, err = uc.View(ctx, &requests.ViewerViewRequest{FileId: "doc"})
+		assert.NoError(t, err)
+	}
+}
+
+func TestViewerPublishConflict(t *testing.T) {
+	ctx := authorContext()
+	storage := gstorage.NewMemoryClient()
+	uc := newTestViewer(&staleStorage{Client: storage})
+
+	// every revision a publish could try is already taken
+	for rev := 1; rev <= maxClaimRevisionAttempts; rev++ {
+		_, err := storage.Write(ctx, uc.objectPath("doc", rev, "meta.json"), strings.NewReader("{}"))
+		assert.NoError(t, err)
+	}
+
+	_, err := uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "doc"})
+	assert.Equal(t, ErrRevisionConflict, err)
+}
+
+func TestViewerRevisions(t *testing.T) {
+	ctx := ctx_helper.AppendSession(context.Background(), &entities.UserSession{Email: "author@example.com"})
+	storage := gstorage.NewMemoryClient()
+	uc := newTestViewer(storage)
+
+	for i := 0; i < 5; i++ {
+		_, err := uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "doc"})
+		assert.NoError(t, err)
+	}
+
+	// a revision still being staged is not listed
+	_, err := storage.Write(ctx, uc.objectPath("doc", 6, "meta.json"), strings.NewReader("{}"))
+	assert.NoError(t, err)
+
+	res, err := uc.Revisions(ctx, &requests.ViewerRevisionsRequest{FileId: "doc", Offset: 1, Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, res.Total)
+	assert.Len(t, res.Revisions, 2)
+	assert.Equal(t, 4, res.Revisions[0].Revision)
+	assert.Equal(t, 3, res.Revisions[1].Revision)
+	assert.Equal(t, "author@example.com", res.Revisions[0].PublishedBy)
+
+	res, err = uc.Revisions(ctx, &requests.ViewerRevisionsRequest{FileId: "doc", Offset: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, res.Total)
+	assert.Empty(t, res.Revisions)
+}
+
+func TestViewerPromote(t *testing.T) {
+	ctx := ctx_helper.AppendSession(context.Background(), &entities.UserSession{Email: "reviewer@example.com"})
+	uc := newTestViewer(gstorage.NewMemoryClient())
+
+	for i := 0; i < 3; i++ {
+		_, err := uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "doc"})
+		assert.NoError(t, err)
+	}
+
+	_, err := uc.Promote(context.Background(), &requests.ViewerPromoteRequest{FileId: "doc", Revision: 1})
+	assert.Equal(t, ErrUnauthorized, err)
+
+	_, err = uc.Promote(ctx, &requests.ViewerPromoteRequest{FileId: "doc", Revision: 7})
+	assert.Equal(t, ErrNotFound, err)
+
+	res, err := uc.Promote(ctx, &requests.ViewerPromoteRequest{FileId: "doc", Revision: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, res.Revision)
+
+	metaRes, err := uc.Meta(ctx, &requests.ViewerMetaRequest{FileId: "doc"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, metaRes.Meta.Revision)
+	assert.Equal(t, "reviewer@example.com", metaRes.Meta.PromotedBy)
+	assert.NotNil(t, metaRes.Meta.PromotedDate)
+
+	// the next publish still gets a fresh revision and becomes latest
+	publishRes, err := uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "doc"})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, publishRes.Revision)
+
+	metaRes, err = uc.Meta(ctx, &requests.ViewerMetaRequest{FileId: "doc"})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, metaRes.Meta.Revision)
+	assert.Empty(t, metaRes.Meta.PromotedBy)
+}
+
+func TestViewerPromoteThenPublish(t *testing.T) {
+	ctx := ctx_helper.AppendSession(context.Background(), &entities.UserSession{Email: "reviewer@example.com"})
+	uc := newTestViewer(gstorage.NewMemoryClient())
+
+	const published = maxClaimRevisionAttempts + 3
+	for i := 0; i < published; i++ {
+		_, err := uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "doc"})
+		assert.NoError(t, err)
+	}
+
+	// latest points more than maxClaimRevisionAttempts revisions back
+	_, err := uc.Promote(ctx, &requests.ViewerPromoteRequest{FileId: "doc", Revision: 1})
+	assert.NoError(t, err)
+
+	res, err := uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "doc"})
+	assert.NoError(t, err)
+	assert.Equal(t, published+1, res.Revision)
+
+	metaRes, err := uc.Meta(ctx, &requests.ViewerMetaRequest{FileId: "doc"})
+	assert.NoError(t, err)
+	assert.Equal(t, published+1, metaRes.Meta.Revision)
+
+	// deleted revisions are not reused
+	_, err = uc.Delete(ctx, &requests.ViewerDeleteRequest{FileId: "doc", Revision: published + 1})
+	assert.NoError(t, err)
+
+	res, err = uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "doc"})
+	assert.NoError(t, err)
+	assert.Equal(t, published+2, res.Revision)
+}
+
+func TestViewerDelete(t *testing.T) {
+	ctx := ctx_helper.AppendSession(context.Background(), &entities.UserSession{Email: "author@example.com"})
+	storage := gstorage.NewMemoryClient()
+	uc := newTestViewer(storage)
+
+	for i := 0; i < 3; i++ {
+		_, err := uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "doc"})
+		assert.NoError(t, err)
+	}
+
+	// delete a single revision
+	_, err := uc.Delete(ctx, &requests.ViewerDeleteRequest{FileId: "doc", Revision: 2})
+	assert.NoError(t, err)
+
+	_, err = uc.View(ctx, &requests.ViewerViewRequest{FileId: "doc", Revision: 2})
+	assert.Equal(t, ErrNotFound, err)
+	_, err = uc.Meta(ctx, &requests.ViewerMetaRequest{FileId: "doc", Revision: 2})
+	assert.Equal(t, ErrNotFound, err)
+	_, err = uc.Delete(ctx, &requests.ViewerDeleteRequest{FileId: "doc", Revision: 2})
+	assert.Equal(t, ErrNotFound, err)
+
+	tombstone, err := uc.readMeta(ctx, uc.objectPath("doc", 2, "meta.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, "author@example.com", tombstone.DeletedBy)
+
+	_, err = uc.View(ctx, &requests.ViewerViewRequest{FileId: "doc"})
+	assert.NoError(t, err)
+
+	// unpublish, keeping history
+	_, err = uc.Delete(ctx, &requests.ViewerDeleteRequest{FileId: "doc", KeepHistory: true})
+	assert.NoError(t, err)
+
+	_, err = uc.View(ctx, &requests.ViewerViewRequest{FileId: "doc"})
+	assert.Equal(t, ErrNotFound, err)
+	_, err = uc.Meta(ctx, &requests.ViewerMetaRequest{FileId: "doc"})
+	assert.Equal(t, ErrNotFound, err)
+	_, err = uc.View(ctx, &requests.ViewerViewRequest{FileId: "doc", Revision: 1})
+	assert.NoError(t, err)
+
+	// publishing again continues the revision numbering
+	res, err := uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "doc"})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, res.Revision)
+
+	// unpublish with history
+	_, err = uc.Delete(ctx, &requests.ViewerDeleteRequest{FileId: "doc"})
+	assert.NoError(t, err)
+
+	revisions, err := uc.Revisions(ctx, &requests.ViewerRevisionsRequest{FileId: "doc"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, revisions.Total)
+
+	_, err = uc.Delete(ctx, &requests.ViewerDeleteRequest{FileId: "doc"})
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestViewerDeletePartial(t *testing.T) {
+	ctx := authorContext()
+	storage := gstorage.NewMemoryClient()
+	uc := newTestViewer(storage)
+
+	for i := 0; i < 2; i++ {
+		_, err := uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "doc"})
+		assert.NoError(t, err)
+	}
+
+	failing := newTestViewer(&failingDeleteStorage{Client: storage, suffix: codelabFileName})
+	_, err := failing.Delete(ctx, &requests.ViewerDeleteRequest{FileId: "doc", Revision: 1})
+	assert.Error(t, err)
+
+	// the half deleted revision is no longer served, and the delete can be retried
+	_, err = uc.View(ctx, &requests.ViewerViewRequest{FileId: "doc", Revision: 1})
+	assert.Equal(t, ErrNotFound, err)
+
+	_, err = uc.Delete(ctx, &requests.ViewerDeleteRequest{FileId: "doc", Revision: 1})
+	assert.NoError(t, err)
+
+	tombstone, err := uc.readMeta(ctx, uc.objectPath("doc", 1, metaFileName))
+	assert.NoError(t, err)
+	assert.True(t, tombstone.Deleted())
+	_, err = storage.Stat(ctx, uc.objectPath("doc", 1, codelabFileName))
+	assert.True(t, gstorage.IsNotExistError(err))
+}
+
+func TestViewerDiff(t *testing.T) {
+	ctx := authorContext()
+	uc := newTestViewer(gstorage.NewMemoryClient())
+
+	_, err := uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "doc"})
+	assert.NoError(t, err)
+
+	uc.driveClient.(*fakeDriveClient).docs["doc"] = strings.Replace(testCodelabDoc, "<p><span>hello world</span></p>",
+		"<p><span>hello codelab</span></p><h1><span>Next</span></h1><p><span>more</span></p>", 1)
+
+	_, err = uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "doc"})
+	assert.NoError(t, err)
+
+	res, err := uc.Diff(ctx, &requests.ViewerDiffRequest{FileId: "doc", From: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, res.From)
+	assert.Equal(t, 2, res.To)
+	assert.Len(t, res.Steps, 2)
+	assert.Equal(t, entities.StepDiffStatusChanged, res.Steps[0].Status)
+	assert.Equal(t, []diff.Line{
+		{Op: diff.OpDelete, Text: "hello world"},
+		{Op: diff.OpInsert, Text: "hello codelab"},
+	}, res.Steps[0].Text)
+	assert.Equal(t, entities.StepDiffStatusAdded, res.Steps[1].Status)
+	assert.Contains(t, res.Response, "hello codelab")
+
+	res, err = uc.Diff(ctx, &requests.ViewerDiffRequest{FileId: "doc", From: 2, To: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, entities.StepDiffStatusUnchanged, res.Steps[0].Status)
+
+	_, err = uc.Diff(ctx, &requests.ViewerDiffRequest{FileId: "doc", From: 1, To: 3})
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestViewerPublishImages(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n fake image")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/image.png" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write(png)
+	}))
+	defer server.Close()
+
+	ctx := authorContext()
+	storage := gstorage.NewMemoryClient()
+	uc := newTestViewer(storage)
+	uc.httpClient = server.Client()
+	uc.driveClient.(*fakeDriveClient).docs["img"] = strings.Replace(testCodelabDoc, "<p><span>hello world</span></p>",
+		`<p><img src="`+server.URL+`/image.png"></p><p><img src="`+server.URL+`/image.png"></p><p><img src="`+server.URL+`/missing.png"></p>`, 1)
+
+	res, err := uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "img"})
+	assert.NoError(t, err)
+
+	objects, err := storage.List(ctx, "files-test/img/1/img/")
+	assert.NoError(t, err)
+	if !assert.Len(t, objects, 1) {
+		return
+	}
+
+	name := strings.TrimPrefix(objects[0].Name, "files-test/img/1/img/")
+	viewRes, err := uc.View(ctx, &requests.ViewerViewRequest{FileId: "img", Revision: res.Revision})
+	assert.NoError(t, err)
+	assert.Contains(t, viewRes.Response, "/v/img/1/img/"+name)
+	assert.NotContains(t, viewRes.Response, server.URL+"/image.png")
+	assert.Contains(t, viewRes.Response, server.URL+"/missing.png", "images failing to download keep their source")
+
+	imageRes, err := uc.Image(ctx, &requests.ViewerImageRequest{FileId: "img", Name: name})
+	assert.NoError(t, err)
+	assert.Equal(t, png, imageRes.Content)
+	assert.Equal(t, "image/png", imageRes.ContentType)
+
+	_, err = uc.Image(ctx, &requests.ViewerImageRequest{FileId: "img", Revision: 1, Name: "../meta.json"})
+	assert.Equal(t, ErrNotFound, err)
+
+	// images are removed along the revision
+	_, err = uc.Delete(ctx_helper.AppendSession(ctx, &entities.UserSession{Email: "author@example.com"}), &requests.ViewerDeleteRequest{FileId: "img", Revision: 1})
+	assert.NoError(t, err)
+
+	objects, err = storage.List(ctx, "files-test/img/1/img/")
+	assert.NoError(t, err)
+	assert.Empty(t, objects)
+}
+
+func TestViewerPublishImagesInternal(t *testing.T) {
+	var requested int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requested, 1)
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte("\x89PNG\r\n\x1a\n fake image"))
+	}))
+	defer server.Close()
+
+	ctx := authorContext()
+	storage := gstorage.NewMemoryClient()
+	uc := newTestViewer(storage)
+	uc.driveClient.(*fakeDriveClient).docs["img"] = strings.Replace(testCodelabDoc, "<p><span>hello world</span></p>",
+		`<p><img src="`+server.URL+`/image.png"></p>`, 1)
+
+	// the server listens on a loopback address
+	_, err := uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "img"})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&requested))
+
+	objects, err := storage.List(ctx, "files-test/img/1/img/")
+	assert.NoError(t, err)
+	assert.Empty(t, objects)
+
+	viewRes, err := uc.View(ctx, &requests.ViewerViewRequest{FileId: "img"})
+	assert.NoError(t, err)
+	assert.Contains(t, viewRes.Response, server.URL+"/image.png")
+}
+
+func TestViewerPublishMarkdown(t *testing.T) {
+	ctx := authorContext()
+	uc := newTestViewer(gstorage.NewMemoryClient())
+	uc.driveClient.(*fakeDriveClient).docs["codelab.md"] = testCodelabMarkdown
+
+	// markdown file read from drive
+	res, err := uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "codelab.md"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, res.Revision)
+
+	// markdown uploaded with the request
+	res, err = uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "uploaded", Markdown: []byte(testCodelabMarkdown)})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, res.Revision)
+
+	for _, fileId := range []string{"codelab.md", "uploaded"} {
+		viewRes, err := uc.View(ctx, &requests.ViewerViewRequest{FileId: fileId})
+		assert.NoError(t, err)
+		assert.Contains(t, viewRes.Response, "hello markdown")
+
+		metaRes, err := uc.Meta(ctx, &requests.ViewerMetaRequest{FileId: fileId})
+		assert.NoError(t, err)
+		assert.Equal(t, entities.SourceMarkdown, metaRes.Meta.Source)
+		assert.Equal(t, "markdown-codelab", metaRes.Meta.Meta.ID)
+		assert.Equal(t, "Markdown Codelab", metaRes.Meta.Meta.Title)
+	}
+
+	metaRes, err := uc.Meta(ctx, &requests.ViewerMetaRequest{FileId: "doc"})
+	assert.Equal(t, ErrNotFound, err)
+	assert.Nil(t, metaRes)
+
+	_, err = uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "doc"})
+	assert.NoError(t, err)
+	metaRes, err = uc.Meta(ctx, &requests.ViewerMetaRequest{FileId: "doc"})
+	assert.NoError(t, err)
+	assert.Equal(t, entities.SourceGoogleDoc, metaRes.Meta.Source)
+}
+
+func TestViewerViewFormats(t *testing.T) {
+	ctx := authorContext()
+	storage := gstorage.NewMemoryClient()
+	uc := newTestViewer(storage)
+
+	markdown := testCodelabMarkdown + "\n## Next steps\nDuration: 1:00\n\ngood bye\n"
+	_, err := uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "doc", Markdown: []byte(markdown)})
+	assert.NoError(t, err)
+
+	res, err := uc.View(ctx, &requests.ViewerViewRequest{FileId: "doc", Format: entities.FormatMarkdown})
+	assert.NoError(t, err)
+	assert.Contains(t, res.Response, "hello markdown")
+	assert.Contains(t, res.ContentType, "text/markdown")
+
+	// formats are stored once rendered
+	mdPath := "files-test/doc/1/md/index.md"
+	_, err = storage.Write(ctx, mdPath, strings.NewReader("stored"))
+	assert.NoError(t, err)
+
+	res, err = uc.View(ctx, &requests.ViewerViewRequest{FileId: "doc", Revision: 1, Format: entities.FormatMarkdown})
+	assert.NoError(t, err)
+	assert.Equal(t, "stored", res.Response)
+
+	res, err = uc.View(ctx, &requests.ViewerViewRequest{FileId: "doc", Format: entities.FormatMarkdown, Rerender: true})
+	assert.NoError(t, err)
+	assert.Contains(t, res.Response, "hello markdown")
+
+	// offline renders a page per step
+	res, err = uc.View(ctx, &requests.ViewerViewRequest{FileId: "doc", Format: entities.FormatOffline})
+	assert.NoError(t, err)
+	assert.Contains(t, res.Response, "hello markdown")
+	assert.Contains(t, res.Response, `href="step-2.html"`)
+
+	res, err = uc.View(ctx, &requests.ViewerViewRequest{FileId: "doc", Format: entities.FormatOffline, Page: "step-2.html"})
+	assert.NoError(t, err)
+	assert.Contains(t, res.Response, "good bye")
+
+	_, err = storage.Stat(ctx, "files-test/doc/1/offline/step-2.html")
+	assert.NoError(t, err)
+
+	for _, format := range []string{entities.FormatQwiklabs, entities.FormatDevsite} {
+		res, err = uc.View(ctx, &requests.ViewerViewRequest{FileId: "doc", Format: format})
+		assert.NoError(t, err)
+		assert.Contains(t, res.Response, "hello markdown")
+	}
+
+	_, err = uc.View(ctx, &requests.ViewerViewRequest{FileId: "doc", Format: entities.FormatOffline, Page: "step-3.html"})
+	assert.Equal(t, ErrNotFound, err)
+
+	_, err = uc.View(ctx, &requests.ViewerViewRequest{FileId: "doc", Format: "pdf"})
+	assert.Equal(t, ErrUnsupportedFormat, err)
+
+	parseRes, err := uc.Parse(ctx, &requests.ViewerParseRequest{FileId: "doc", Format: entities.FormatMarkdown})
+	assert.NoError(t, err)
+	assert.Contains(t, parseRes.Response, "hello world")
+
+	// stored formats are removed along the revision
+	_, err = uc.Delete(ctx_helper.AppendSession(ctx, &entities.UserSession{Email: "author@example.com"}), &requests.ViewerDeleteRequest{FileId: "doc", Revision: 1})
+	assert.NoError(t, err)
+
+	objects, err := storage.List(ctx, "files-test/doc/1/")
+	assert.NoError(t, err)
+	for _, o := range objects {
+		assert.Equal(t, "files-test/doc/1/meta.json", o.Name)
+	}
+}
+
+func TestViewerRenderContext(t *testing.T) {
+	ctx := authorContext()
+	driveClient := &fakeDriveClient{docs: map[string]string{"doc": testCodelabDoc}}
+	uc := NewViewer(driveClient, nil, gstorage.NewMemoryClient(), "", "", "", "files-test", &entities.RenderContext{
+		Prefix:  "https://cdn.example.com/",
+		Extra:   map[string]string{"channel": "beta"},
+		Origins: []string{"https://other.example.com"},
+	}, nil, nil, nil, "").(*viewerUsecase)
+
+	_, err := uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "doc"})
+	assert.NoError(t, err)
+
+	res, err := uc.View(ctx, &requests.ViewerViewRequest{FileId: "doc"})
+	assert.NoError(t, err)
+	assert.Contains(t, res.Response, `"https://cdn.example.com/codelab-elements/codelab-elements.js"`)
+	assert.Contains(t, res.Response, `environment="web"`)
+
+	metaRes, err := uc.Meta(ctx, &requests.ViewerMetaRequest{FileId: "doc"})
+	assert.NoError(t, err)
+	assert.Equal(t, &entities.RenderContext{
+		Prefix: "https://cdn.example.com",
+		Env:    "web",
+		Extra:  map[string]string{"channel": "beta"},
+	}, metaRes.Meta.Render)
+
+	// codelab metadata override the service configuration
+	markdown := "prefix: https://other.example.com\nenv: kiosk\nchannel: stable\n" + testCodelabMarkdown
+	_, err = uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "md", Markdown: []byte(markdown)})
+	assert.NoError(t, err)
+
+	res, err = uc.View(ctx, &requests.ViewerViewRequest{FileId: "md"})
+	assert.NoError(t, err)
+	assert.Contains(t, res.Response, `"https://other.example.com/codelab-elements/codelab-elements.js"`)
+	assert.Contains(t, res.Response, `environment="kiosk"`)
+
+	metaRes, err = uc.Meta(ctx, &requests.ViewerMetaRequest{FileId: "md"})
+	assert.NoError(t, err)
+	assert.Equal(t, &entities.RenderContext{
+		Prefix: "https://other.example.com",
+		Env:    "kiosk",
+		Extra:  map[string]string{"channel": "stable"},
+	}, metaRes.Meta.Render)
+
+	// prefixes outside the allowed origins are ignored
+	for _, prefix := range []string{
+		"http://other.example.com",
+		"https://evil.example.com",
+		"https://user@other.example.com",
+		"http://169.254.169.254/computeMetadata",
+		"javascript:alert(1)",
+	} {
+		markdown := "prefix: " + prefix + "\n" + testCodelabMarkdown
+		_, err = uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "md", Markdown: []byte(markdown)})
+		assert.NoError(t, err)
+
+		metaRes, err = uc.Meta(ctx, &requests.ViewerMetaRequest{FileId: "md"})
+		assert.NoError(t, err)
+		assert.Equal(t, "https://cdn.example.com", metaRes.Meta.Render.Prefix, prefix)
+	}
+
+	markdown = "prefix: https://cdn.example.com/v2\n" + testCodelabMarkdown
+	_, err = uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "md", Markdown: []byte(markdown)})
+	assert.NoError(t, err)
+
+	metaRes, err = uc.Meta(ctx, &requests.ViewerMetaRequest{FileId: "md"})
+	assert.NoError(t, err)
+	assert.Equal(t, "https://cdn.example.com/v2", metaRes.Meta.Render.Prefix)
+}
+
+func TestViewerThemes(t *testing.T) {
+	ctx := authorContext()
+	storage := gstorage.NewMemoryClient()
+	_, err := storage.Write(ctx, "themes/dark.html", bytes.NewBufferString(`<html class="{{.Theme}}"><body>{{.Codelab}}</body></html>`))
+	assert.NoError(t, err)
+
+	driveClient := &fakeDriveClient{docs: map[string]string{"doc": testCodelabDoc}}
+	uc := NewViewer(driveClient, nil, storage, "", "", "", "files-test", nil, theme.NewLoader(storage, "themes"), nil, nil, "").(*viewerUsecase)
+
+	// theme selected by the codelab metadata
+	_, err = uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "md", Markdown: []byte("theme: dark\n" + testCodelabMarkdown)})
+	assert.NoError(t, err)
+
+	res, err := uc.View(ctx, &requests.ViewerViewRequest{FileId: "md"})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(res.Response, `<html class="dark"><body><google-codelab-analytics`))
+	assert.Contains(t, res.Response, `id="markdown-codelab"`)
+	assert.Contains(t, res.Response, `environment="web"`)
+	assert.True(t, strings.HasSuffix(res.Response, `</google-codelab></body></html>`))
+	assert.NotContains(t, res.Response, "codelab-elements.js", "the page around the codelab element is the theme's")
+	assert.Contains(t, res.Response, "hello markdown")
+
+	metaRes, err := uc.Meta(ctx, &requests.ViewerMetaRequest{FileId: "md"})
+	assert.NoError(t, err)
+	assert.Equal(t, "dark", metaRes.Meta.Render.Theme)
+
+	// theme previewed without publishing
+	parseRes, err := uc.Parse(ctx, &requests.ViewerParseRequest{FileId: "doc"})
+	assert.NoError(t, err)
+	assert.NotContains(t, parseRes.Response, `<html class="dark">`)
+
+	parseRes, err = uc.Parse(ctx, &requests.ViewerParseRequest{FileId: "doc", Theme: "dark"})
+	assert.NoError(t, err)
+	assert.Contains(t, parseRes.Response, `<html class="dark">`)
+	assert.Contains(t, parseRes.Response, "hello world")
+
+	_, err = uc.Parse(ctx, &requests.ViewerParseRequest{FileId: "doc", Theme: "missing"})
+	assert.Equal(t, ErrUnknownTheme, err)
+}
+
+func TestViewerLint(t *testing.T) {
+	ctx := ctx_helper.AppendSession(context.Background(), &entities.UserSession{Email: "author@example.com"})
+	uc := newTestViewer(gstorage.NewMemoryClient())
+
+	markdown := `id: lint-codelab
+
+# Lint Codelab
+
+## Overview
+
+#### Deep heading
+
+![](img.png)
+`
+	uc.driveClient.(*fakeDriveClient).docs["lint.md"] = markdown
+
+	res, err := uc.Lint(ctx, &requests.ViewerLintRequest{FileId: "lint.md"})
+	assert.NoError(t, err)
+	assert.False(t, res.Valid)
+
+	issues := map[string]*entities.LintIssue{}
+	for _, issue := range res.Issues {
+		issues[issue.Rule] = issue
+	}
+	assert.Equal(t, &entities.LintIssue{Rule: entities.LintRuleMetadata, Severity: entities.LintSeverityError, Message: "missing summary metadata"}, issues[entities.LintRuleMetadata])
+	assert.Equal(t, entities.LintSeverityWarning, issues[entities.LintRuleFeedback].Severity)
+	assert.Equal(t, &entities.LintIssue{Rule: entities.LintRuleDuration, Severity: entities.LintSeverityWarning, Step: 1, Message: "step has no duration"}, issues[entities.LintRuleDuration])
+	assert.Equal(t, &entities.LintIssue{Rule: entities.LintRuleImageAlt, Severity: entities.LintSeverityWarning, Step: 1, Message: "image img.png has no alt text"}, issues[entities.LintRuleImageAlt])
+	assert.Equal(t, &entities.LintIssue{Rule: entities.LintRuleHeadingLevel, Severity: entities.LintSeverityWarning, Step: 1, Message: "heading level 3 follows level 1"}, issues[entities.LintRuleHeadingLevel])
+	assert.Nil(t, issues[entities.LintRuleSteps])
+	assert.Nil(t, issues[entities.LintRuleEmptyStep])
+
+	// errors refuse the publish
+	publishRes, err := uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "lint.md"})
+	assert.Equal(t, ErrLintFailed, err)
+	assert.Equal(t, res.Issues, publishRes.Issues)
+
+	_, err = uc.Meta(ctx, &requests.ViewerMetaRequest{FileId: "lint.md"})
+	assert.Equal(t, ErrNotFound, err)
+
+	// rules are configurable
+	uc.lintConfig = newLintConfig(&entities.LintConfig{
+		Rules:    map[string]string{entities.LintRuleFeedback: entities.LintSeverityOff, entities.LintRuleDuration: entities.LintSeverityError},
+		Metadata: []string{requests.ViewerDraftKeySlug},
+	})
+
+	res, err = uc.Lint(ctx, &requests.ViewerLintRequest{FileId: "lint.md"})
+	assert.NoError(t, err)
+	assert.False(t, res.Valid)
+	assert.Len(t, res.Issues, 3)
+	assert.Equal(t, entities.LintRuleDuration, res.Issues[0].Rule)
+	assert.Equal(t, entities.LintSeverityError, res.Issues[0].Severity)
+
+	uc.lintConfig.Rules[entities.LintRuleDuration] = entities.LintSeverityWarning
+	publishRes, err = uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "lint.md"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, publishRes.Revision)
+	assert.Len(t, publishRes.Issues, 3)
+
+	// linting reads the document, it needs a session and a role
+	_, err = uc.Lint(context.Background(), &requests.ViewerLintRequest{FileId: "lint.md"})
+	assert.Equal(t, ErrUnauthorized, err)
+
+	uc.policies = policy.NewMemoryStore()
+	_, err = uc.Lint(ctx, &requests.ViewerLintRequest{FileId: "lint.md"})
+	assert.Equal(t, ErrForbidden, err)
+}
+
+func TestViewerLinks(t *testing.T) {
+	ctx := ctx_helper.AppendSession(context.Background(), &entities.UserSession{Email: "author@example.com"})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ok" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	uc := newTestViewer(gstorage.NewMemoryClient())
+	uc.linkChecker = linkcheck.NewChecker(server.Client(), linkCheckConcurrency, 0)
+	uc.driveClient.(*fakeDriveClient).docs["links.md"] = `summary: a summary
+id: links-codelab
+
+# Links Codelab
+
+## Overview
+Duration: 1:00
+
+read the [docs](` + server.URL + `/ok) and the [old docs](` + server.URL + `/gone)
+
+## Next
+Duration: 1:00
+
+the [docs](` + server.URL + `/ok) again, and a [relative link](other.html)
+`
+
+	res, err := uc.Links(ctx, &requests.ViewerLinksRequest{FileId: "links.md"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, res.Broken)
+	assert.Equal(t, []*entities.LinkResult{
+		{Step: 1, URL: server.URL + "/ok", Status: http.StatusOK},
+		{Step: 1, URL: server.URL + "/gone", Status: http.StatusNotFound, Broken: true},
+		{Step: 2, URL: server.URL + "/ok", Status: http.StatusOK},
+	}, res.Links)
+
+	// broken links refuse the publish when checked
+	publishRes, err := uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "links.md", CheckLinks: true})
+	assert.Equal(t, ErrBrokenLinks, err)
+	assert.Equal(t, []*entities.LinkResult{res.Links[1]}, publishRes.Links)
+
+	publishRes, err = uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "links.md"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, publishRes.Revision)
+
+	// checking links needs a session and a role
+	_, err = uc.Links(context.Background(), &requests.ViewerLinksRequest{FileId: "links.md"})
+	assert.Equal(t, ErrUnauthorized, err)
+
+	uc.policies = policy.NewMemoryStore()
+	_, err = uc.Links(ctx, &requests.ViewerLinksRequest{FileId: "links.md"})
+	assert.Equal(t, ErrForbidden, err)
+}
+
+func TestViewerLinksInternal(t *testing.T) {
+	var requested int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requested, 1)
+	}))
+	defer server.Close()
+
+	ctx := ctx_helper.AppendSession(context.Background(), &entities.UserSession{Email: "author@example.com"})
+	uc := newTestViewer(gstorage.NewMemoryClient())
+	uc.driveClient.(*fakeDriveClient).docs["links.md"] = "id: links-codelab\n\n# Links Codelab\n\n## Overview\n\n" +
+		"the [server](" + server.URL + "/ok) and the [metadata](http://169.254.169.254/computeMetadata/v1/)\n"
+
+	res, err := uc.Links(ctx, &requests.ViewerLinksRequest{FileId: "links.md"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, res.Broken)
+	for _, link := range res.Links {
+		assert.Contains(t, link.Error, netguard.ErrBlockedAddress.Error())
+	}
+	assert.Equal(t, int32(0), atomic.LoadInt32(&requested))
+}
+
+func TestViewerSteps(t *testing.T) {
+	ctx := authorContext()
+	uc := newTestViewer(gstorage.NewMemoryClient())
+
+	markdown := testCodelabMarkdown + `
+## Next Step
+Duration: 2:00
+
+` + "```go\nfmt.Println(\"hi\")\n```\n"
+	_, err := uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "md", Markdown: []byte(markdown)})
+	assert.NoError(t, err)
+
+	res, err := uc.Steps(ctx, &requests.ViewerStepsRequest{FileId: "md"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, res.Revision)
+	assert.Equal(t, 2, res.Total)
+	assert.Len(t, res.Steps, 2)
+	assert.Equal(t, 1, res.Steps[0].Index)
+	assert.Equal(t, "Overview", res.Steps[0].Title)
+	assert.Equal(t, 1, res.Steps[0].Duration)
+	assert.Equal(t, "list", res.Steps[0].Content.Type)
+
+	res, err = uc.Steps(ctx, &requests.ViewerStepsRequest{FileId: "md", Revision: 1, Step: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, res.Total)
+	assert.Len(t, res.Steps, 1)
+	assert.Equal(t, "Next Step", res.Steps[0].Title)
+	assert.Equal(t, 2, res.Steps[0].Duration)
+
+	var code *codelab.Node
+	for _, n := range res.Steps[0].Content.Nodes {
+		if n.Type == "code" {
+			code = n
+		}
+	}
+	if assert.NotNil(t, code) {
+		assert.Equal(t, "language-go", code.Lang)
+		assert.Contains(t, code.Value, `fmt.Println("hi")`)
+	}
+
+	_, err = uc.Steps(ctx, &requests.ViewerStepsRequest{FileId: "md", Step: 3})
+	assert.Equal(t, ErrNotFound, err)
+
+	_, err = uc.Steps(ctx, &requests.ViewerStepsRequest{FileId: "missing"})
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestViewerPublishSummary(t *testing.T) {
+	ctx := authorContext()
+	uc := newTestViewer(gstorage.NewMemoryClient())
+
+	markdown := testCodelabMarkdown + `
+## Next Step
+Duration: 2:00
+
+one two three ![diagram](diagram.png)
+
+` + "```go\nfmt.Println(\"hi\")\n```\n"
+	_, err := uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "md", Markdown: []byte(markdown)})
+	assert.NoError(t, err)
+
+	res, err := uc.Meta(ctx, &requests.ViewerMetaRequest{FileId: "md"})
+	assert.NoError(t, err)
+	assert.Equal(t, []*entities.TocEntry{
+		{Index: 1, Title: "Overview", Duration: 1},
+		{Index: 2, Title: "Next Step", Duration: 2},
+	}, res.Meta.Toc)
+	assert.Equal(t, 8, res.Meta.WordCount)
+	assert.Equal(t, 1, res.Meta.ReadingTime)
+	assert.Equal(t, 1, res.Meta.CodeBlocks)
+	assert.Equal(t, 1, res.Meta.Images)
+}
+
+func TestViewerRoles(t *testing.T) {
+	ctx := context.Background()
+	uc := newTestViewer(gstorage.NewMemoryClient())
+	uc.adminEmail = "admin@example.com"
+	uc.policies = policy.NewMemoryStore()
+	uc.defaultRole = entities.RoleViewer
+
+	admin := ctx_helper.AppendSession(ctx, &entities.UserSession{Email: "admin@example.com"})
+	author := ctx_helper.AppendSession(ctx, &entities.UserSession{Email: "author@example.com", Domain: "example.com"})
+	member := ctx_helper.AppendSession(ctx, &entities.UserSession{Email: "member@example.com", Domain: "example.com"})
+	guest := ctx_helper.AppendSession(ctx, &entities.UserSession{Email: "guest@other.com", Domain: "other.com"})
+
+	_, err := uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "doc"})
+	assert.Equal(t, ErrUnauthorized, err)
+
+	_, err = uc.Publish(author, &requests.ViewerPublishRequest{FileId: "doc"})
+	assert.Equal(t, ErrForbidden, err)
+
+	_, err = uc.GrantRole(author, &requests.ViewerGrantRoleRequest{Subject: "author@example.com", Role: entities.RoleAuthor})
+	assert.Equal(t, ErrForbidden, err)
+
+	_, err = uc.GrantRole(admin, &requests.ViewerGrantRoleRequest{Subject: "not a subject", Role: entities.RoleAuthor})
+	assert.Equal(t, ErrInvalidSubject, err)
+
+	_, err = uc.GrantRole(admin, &requests.ViewerGrantRoleRequest{Subject: "example.com", Role: "owner"})
+	assert.Equal(t, ErrInvalidRole, err)
+
+	// the role granted to the email takes precedence over the role granted to the domain
+	_, err = uc.GrantRole(admin, &requests.ViewerGrantRoleRequest{Subject: "Author@Example.com", Role: entities.RoleAuthor})
+	assert.NoError(t, err)
+	_, err = uc.GrantRole(admin, &requests.ViewerGrantRoleRequest{Subject: "example.com", Role: entities.RoleReviewer})
+	assert.NoError(t, err)
+
+	_, err = uc.Publish(author, &requests.ViewerPublishRequest{FileId: "md", Markdown: []byte(testCodelabMarkdown)})
+	assert.NoError(t, err)
+
+	_, err = uc.Promote(author, &requests.ViewerPromoteRequest{FileId: "md", Revision: 1})
+	assert.Equal(t, ErrForbidden, err)
+
+	_, err = uc.Publish(member, &requests.ViewerPublishRequest{FileId: "doc"})
+	assert.NoError(t, err)
+
+	_, err = uc.Promote(member, &requests.ViewerPromoteRequest{FileId: "doc", Revision: 1})
+	assert.NoError(t, err)
+
+	_, err = uc.Meta(guest, &requests.ViewerMetaRequest{FileId: "doc"})
+	assert.NoError(t, err)
+
+	_, err = uc.Publish(guest, &requests.ViewerPublishRequest{FileId: "doc"})
+	assert.Equal(t, ErrForbidden, err)
+
+	res, err := uc.Roles(admin, &requests.ViewerRolesRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, entities.RoleViewer, res.DefaultRole)
+	assert.Equal(t, []*policy.Grant{
+		{Subject: "author@example.com", Role: entities.RoleAuthor},
+		{Subject: "example.com", Role: entities.RoleReviewer},
+	}, res.Grants)
+
+	_, err = uc.RevokeRole(admin, &requests.ViewerRevokeRoleRequest{Subject: "example.com"})
+	assert.NoError(t, err)
+
+	_, err = uc.RevokeRole(admin, &requests.ViewerRevokeRoleRequest{Subject: "example.com"})
+	assert.Equal(t, ErrNotFound, err)
+
+	_, err = uc.Promote(member, &requests.ViewerPromoteRequest{FileId: "doc", Revision: 1})
+	assert.Equal(t, ErrForbidden, err)
+}
@@ -0,0 +1,185 @@
+package usecases
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	cp "github.com/foxfoxio/codelabs-preview-go/internal"
+	"github.com/foxfoxio/codelabs-preview-go/internal/gstorage"
+	"github.com/foxfoxio/codelabs-preview-go/internal/stopwatch"
+	"github.com/foxfoxio/codelabs-preview-go/internal/utils"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities/requests"
+	"regexp"
+	"strings"
+)
+
+var ErrInvalidVisibility = errors.New("invalid visibility")
+
+var domainPattern = regexp.MustCompile(`^[a-z0-9.-]+\.[a-z]+$`)
+
+// readVisibility returns the visibility of the codelab, nil when it is public or has never been published.
+func (uc *viewerUsecase) readVisibility(ctx context.Context, fileId string) (*entities.Visibility, error) {
+	latestMeta, err := uc.readMeta(ctx, uc.objectPath(fileId, 0, metaFileName))
+	if err == ErrNotFound {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return latestMeta.Visibility, nil
+}
+
+// authorizeVisibility checks that the user of the session may view a codelab with visibility.
+// Owners, collaborators and admins view the codelabs they manage whatever their visibility.
+func (uc *viewerUsecase) authorizeVisibility(ctx context.Context, fileId string, visibility *entities.Visibility) error {
+	log := cp.Log(ctx, "ViewerUsecase.authorizeVisibility").WithField("fileId", fileId)
+
+	if visibility.Public() {
+		return nil
+	}
+
+	session := getSession(ctx)
+	if session == nil {
+		log.WithField("mode", visibility.Mode).Info("sign in required")
+		return ErrUnauthorized
+	}
+
+	if visibility.Allows(session) {
+		return nil
+	}
+
+	role, err := uc.sessionRole(ctx, session)
+	if err != nil {
+		log.WithError(err).Error("resolve role failed")
+		return err
+	}
+
+	if role == entities.RoleAdmin {
+		return nil
+	}
+
+	ownership, _, err := uc.readOwnership(ctx, fileId)
+	if err != nil && err != ErrNotFound {
+		log.WithError(err).Error("read ownership failed")
+		return err
+	}
+
+	if ownership != nil && ownership.Allows(session.Email) {
+		return nil
+	}
+
+	log.WithField("email", session.Email).WithField("mode", visibility.Mode).Error("codelab not visible")
+	return ErrForbidden
+}
+
+// authorizeView checks that the user of the session may view the published codelab.
+func (uc *viewerUsecase) authorizeView(ctx context.Context, fileId string) error {
+	visibility, err := uc.readVisibility(ctx, fileId)
+	if err != nil {
+		return err
+	}
+
+	return uc.authorizeVisibility(ctx, fileId, visibility)
+}
+
+// newVisibility validates the visibility and returns a copy with lower cased domains and emails.
+func newVisibility(v *entities.Visibility) (*entities.Visibility, error) {
+	if v == nil {
+		return nil, ErrInvalidVisibility
+	}
+
+	visibility := &entities.Visibility{Mode: v.Mode}
+	switch v.Mode {
+	case entities.VisibilityPublic:
+		return visibility, nil
+	case entities.VisibilityDomain:
+		for _, domain := range v.Domains {
+			domain = strings.ToLower(strings.TrimSpace(domain))
+			if !domainPattern.MatchString(domain) {
+				return nil, ErrInvalidVisibility
+			}
+			visibility.Domains = append(visibility.Domains, domain)
+		}
+	case entities.VisibilityAllowList:
+		for _, email := range v.Emails {
+			email = strings.ToLower(strings.TrimSpace(email))
+			if !emailPattern.MatchString(email) {
+				return nil, ErrInvalidVisibility
+			}
+			visibility.Emails = append(visibility.Emails, email)
+		}
+	default:
+		return nil, ErrInvalidVisibility
+	}
+
+	if len(visibility.Domains) == 0 && len(visibility.Emails) == 0 {
+		return nil, ErrInvalidVisibility
+	}
+
+	return visibility, nil
+}
+
+// SetVisibility changes who may view the published codelab, the visibility is kept by the revisions published
+// and promoted afterwards.
+func (uc *viewerUsecase) SetVisibility(ctx context.Context, request *requests.ViewerSetVisibilityRequest) (*requests.ViewerSetVisibilityResponse, error) {
+	log := cp.Log(ctx, "ViewerUsecase.SetVisibility").WithField("fileId", request.FileId)
+	defer stopwatch.StartWithLogger(log).Stop()
+
+	session := getSession(ctx)
+
+	if session == nil {
+		log.Errorf("get user session failed")
+		return nil, ErrUnauthorized
+	}
+
+	if err := uc.authorize(ctx, entities.RoleAuthor); err != nil {
+		return nil, err
+	}
+
+	if err := uc.authorizeFile(ctx, request.FileId, claimNone); err != nil {
+		return nil, err
+	}
+
+	visibility, err := newVisibility(request.Visibility)
+	if err != nil {
+		return nil, err
+	}
+
+	latestMetaPath := uc.objectPath(request.FileId, 0, metaFileName)
+
+	for attempt := 0; attempt < maxFlipLatestAttempts; attempt++ {
+		attrs, err := uc.gStorageClient.Stat(ctx, latestMetaPath)
+		if err != nil {
+			if gstorage.IsNotExistError(err) {
+				return nil, ErrNotFound
+			}
+			return nil, err
+		}
+
+		latestMeta, err := uc.readMeta(ctx, latestMetaPath)
+		if err != nil {
+			log.WithError(err).WithField("path", latestMetaPath).Error("read latest meta file failed")
+			return nil, err
+		}
+
+		latestMeta.Visibility = visibility
+
+		size, err := uc.gStorageClient.WriteIfGenerationMatch(ctx, latestMetaPath, bytes.NewBufferString(utils.StringifyIndent(latestMeta)), attrs.Generation)
+		if err == nil {
+			log.WithField("size", size).WithField("mode", visibility.Mode).WithField("email", session.Email).Info("visibility changed")
+			return &requests.ViewerSetVisibilityResponse{Visibility: visibility}, nil
+		}
+
+		if !gstorage.IsPreconditionFailedError(err) {
+			log.WithError(err).WithField("path", latestMetaPath).Error("write latest meta file failed")
+			return nil, err
+		}
+
+		log.Warn("latest changed, retrying")
+	}
+
+	return nil, ErrRevisionConflict
+}
@@ -0,0 +1,188 @@
+package usecases
+
+import (
+	"context"
+	"github.com/foxfoxio/codelabs-preview-go/internal/ctx_helper"
+	"github.com/foxfoxio/codelabs-preview-go/internal/gstorage"
+	"github.com/foxfoxio/codelabs-preview-go/internal/policy"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities"
+	"github.com/foxfoxio/codelabs-preview-go/svcs/previewer/entities/requests"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestViewerVisibility(t *testing.T) {
+	ctx := context.Background()
+	uc := newTestViewer(gstorage.NewMemoryClient())
+	uc.adminEmail = "admin@example.com"
+	uc.policies = policy.NewMemoryStore()
+	uc.defaultRole = entities.RoleReviewer
+
+	owner := ctx_helper.AppendSession(ctx, &entities.UserSession{Email: "owner@example.com", Domain: "example.com"})
+	employee := ctx_helper.AppendSession(ctx, &entities.UserSession{Email: "employee@example.com", Domain: "example.com"})
+	guest := ctx_helper.AppendSession(ctx, &entities.UserSession{Email: "guest@other.com", Domain: "other.com"})
+
+	_, err := uc.Publish(owner, &requests.ViewerPublishRequest{FileId: "doc"})
+	assert.NoError(t, err)
+
+	_, err = uc.View(ctx, &requests.ViewerViewRequest{FileId: "doc"})
+	assert.NoError(t, err, "codelabs are public by default")
+
+	_, err = uc.SetVisibility(owner, &requests.ViewerSetVisibilityRequest{FileId: "doc", Visibility: &entities.Visibility{Mode: entities.VisibilityDomain}})
+	assert.Equal(t, ErrInvalidVisibility, err)
+
+	_, err = uc.SetVisibility(owner, &requests.ViewerSetVisibilityRequest{FileId: "missing", Visibility: &entities.Visibility{Mode: entities.VisibilityPublic}})
+	assert.Equal(t, ErrNotFound, err)
+
+	res, err := uc.SetVisibility(owner, &requests.ViewerSetVisibilityRequest{FileId: "doc", Visibility: &entities.Visibility{Mode: entities.VisibilityDomain, Domains: []string{"Example.com"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"example.com"}, res.Visibility.Domains)
+
+	_, err = uc.View(ctx, &requests.ViewerViewRequest{FileId: "doc"})
+	assert.Equal(t, ErrUnauthorized, err)
+
+	_, err = uc.View(guest, &requests.ViewerViewRequest{FileId: "doc", Revision: 1})
+	assert.Equal(t, ErrForbidden, err)
+
+	_, err = uc.Steps(guest, &requests.ViewerStepsRequest{FileId: "doc"})
+	assert.Equal(t, ErrForbidden, err)
+
+	_, err = uc.View(employee, &requests.ViewerViewRequest{FileId: "doc"})
+	assert.NoError(t, err)
+
+	// visibility is kept by the revisions published and promoted afterwards
+	_, err = uc.Publish(owner, &requests.ViewerPublishRequest{FileId: "doc"})
+	assert.NoError(t, err)
+	_, err = uc.Promote(owner, &requests.ViewerPromoteRequest{FileId: "doc", Revision: 1})
+	assert.NoError(t, err)
+
+	metaRes, err := uc.Meta(employee, &requests.ViewerMetaRequest{FileId: "doc"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, metaRes.Meta.Revision)
+	if assert.NotNil(t, metaRes.Meta.Visibility) {
+		assert.Equal(t, entities.VisibilityDomain, metaRes.Meta.Visibility.Mode)
+	}
+
+	_, err = uc.SetVisibility(owner, &requests.ViewerSetVisibilityRequest{FileId: "doc", Visibility: &entities.Visibility{Mode: entities.VisibilityAllowList, Emails: []string{"guest@other.com"}}})
+	assert.NoError(t, err)
+
+	_, err = uc.View(guest, &requests.ViewerViewRequest{FileId: "doc"})
+	assert.NoError(t, err)
+
+	_, err = uc.View(employee, &requests.ViewerViewRequest{FileId: "doc"})
+	assert.Equal(t, ErrForbidden, err)
+
+	_, err = uc.View(owner, &requests.ViewerViewRequest{FileId: "doc"})
+	assert.NoError(t, err, "owners view their codelabs")
+
+	_, err = uc.Meta(ctx_helper.AppendSession(ctx, &entities.UserSession{Email: "admin@example.com"}), &requests.ViewerMetaRequest{FileId: "doc"})
+	assert.NoError(t, err, "admins view every codelab")
+}
+
+func TestViewerVisibilityDraft(t *testing.T) {
+	ctx := context.Background()
+	uc := newTestViewer(gstorage.NewMemoryClient())
+	uc.policies = policy.NewMemoryStore()
+	uc.defaultRole = entities.RoleReviewer
+
+	owner := ctx_helper.AppendSession(ctx, &entities.UserSession{Email: "owner@example.com", Domain: "example.com"})
+	employee := ctx_helper.AppendSession(ctx, &entities.UserSession{Email: "employee@example.com", Domain: "example.com"})
+	guest := ctx_helper.AppendSession(ctx, &entities.UserSession{Email: "guest@other.com", Domain: "other.com"})
+
+	_, err := uc.Publish(owner, &requests.ViewerPublishRequest{FileId: "doc"})
+	assert.NoError(t, err)
+	_, err = uc.SetVisibility(owner, &requests.ViewerSetVisibilityRequest{FileId: "doc", Visibility: &entities.Visibility{Mode: entities.VisibilityDomain, Domains: []string{"example.com"}}})
+	assert.NoError(t, err)
+
+	// previews, lint and links read the document, which is restricted like the published codelab
+	_, err = uc.Parse(ctx, &requests.ViewerParseRequest{FileId: "doc"})
+	assert.Equal(t, ErrUnauthorized, err)
+	_, err = uc.Parse(guest, &requests.ViewerParseRequest{FileId: "doc", Format: entities.FormatMarkdown})
+	assert.Equal(t, ErrForbidden, err)
+
+	res, err := uc.Parse(employee, &requests.ViewerParseRequest{FileId: "doc"})
+	assert.NoError(t, err)
+	assert.Contains(t, res.Response, "hello world")
+
+	_, err = uc.Lint(ctx, &requests.ViewerLintRequest{FileId: "doc"})
+	assert.Equal(t, ErrUnauthorized, err)
+	_, err = uc.Lint(guest, &requests.ViewerLintRequest{FileId: "doc"})
+	assert.Equal(t, ErrForbidden, err)
+	_, err = uc.Lint(owner, &requests.ViewerLintRequest{FileId: "doc"})
+	assert.NoError(t, err)
+
+	_, err = uc.Links(ctx, &requests.ViewerLinksRequest{FileId: "doc"})
+	assert.Equal(t, ErrUnauthorized, err)
+	_, err = uc.Links(guest, &requests.ViewerLinksRequest{FileId: "doc"})
+	assert.Equal(t, ErrForbidden, err)
+	_, err = uc.Links(owner, &requests.ViewerLinksRequest{FileId: "doc"})
+	assert.NoError(t, err)
+
+	// documents never published are previewed by anyone, as before visibility existed
+	uc.driveClient.(*fakeDriveClient).docs["draft"] = testCodelabDoc
+	_, err = uc.Parse(ctx, &requests.ViewerParseRequest{FileId: "draft"})
+	assert.NoError(t, err)
+}
+
+// visibilityRaceStorage changes the visibility right before the first conditional write of latest it sees.
+type visibilityRaceStorage struct {
+	gstorage.Client
+	race func()
+}
+
+func (s *visibilityRaceStorage) WriteIfGenerationMatch(ctx context.Context, object string, content io.Reader, generation int64) (int64, error) {
+	if race := s.race; race != nil && strings.HasSuffix(object, "/latest/"+metaFileName) {
+		s.race = nil
+		race()
+	}
+	return s.Client.WriteIfGenerationMatch(ctx, object, content, generation)
+}
+
+func TestViewerVisibilityConcurrent(t *testing.T) {
+	ctx := authorContext()
+	storage := &visibilityRaceStorage{Client: gstorage.NewMemoryClient()}
+	uc := newTestViewer(storage)
+
+	restricted := &entities.Visibility{Mode: entities.VisibilityAllowList, Emails: []string{"guest@other.com"}}
+	restrict := func() {
+		_, err := uc.SetVisibility(ctx, &requests.ViewerSetVisibilityRequest{FileId: "doc", Visibility: restricted})
+		assert.NoError(t, err)
+	}
+
+	_, err := uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "doc"})
+	assert.NoError(t, err)
+	_, err = uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "doc"})
+	assert.NoError(t, err)
+
+	// promotes racing with a visibility change retry with the new visibility
+	storage.race = restrict
+	_, err = uc.Promote(ctx, &requests.ViewerPromoteRequest{FileId: "doc", Revision: 1})
+	assert.NoError(t, err)
+
+	meta, err := uc.readMeta(ctx, uc.objectPath("doc", 0, metaFileName))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, meta.Revision)
+	if assert.NotNil(t, meta.Visibility) {
+		assert.Equal(t, entities.VisibilityAllowList, meta.Visibility.Mode)
+	}
+
+	// so do deletes, the restriction holds if the codelab is published again
+	restricted = &entities.Visibility{Mode: entities.VisibilityDomain, Domains: []string{"example.com"}}
+	storage.race = restrict
+	_, err = uc.Delete(ctx, &requests.ViewerDeleteRequest{FileId: "doc", KeepHistory: true})
+	assert.NoError(t, err)
+
+	meta, err = uc.readMeta(ctx, uc.objectPath("doc", 0, metaFileName))
+	assert.NoError(t, err)
+	assert.True(t, meta.Deleted())
+	if assert.NotNil(t, meta.Visibility) {
+		assert.Equal(t, entities.VisibilityDomain, meta.Visibility.Mode)
+	}
+
+	_, err = uc.Publish(ctx, &requests.ViewerPublishRequest{FileId: "doc"})
+	assert.NoError(t, err)
+	_, err = uc.View(context.Background(), &requests.ViewerViewRequest{FileId: "doc"})
+	assert.Equal(t, ErrUnauthorized, err)
+}